@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// Accessibility is GTFS's wheelchair_accessible, the zero value is unknown
+type Accessibility int
+
+const (
+	accessibilityUnknown Accessibility = 0
+	accessibilityYes     Accessibility = 1
+	accessibilityNo      Accessibility = 2
+)
+
+var accessibilityNames = map[Accessibility]string{
+	accessibilityUnknown: "unknown",
+	accessibilityYes:     "yes",
+	accessibilityNo:      "no",
+}
+
+// parseAccessibility treats anything but an explicit yes or no, including a missing column or "0", as unknown
+func parseAccessibility(value string) Accessibility {
+	switch value {
+	case "1":
+		return accessibilityYes
+	case "2":
+		return accessibilityNo
+	}
+	return accessibilityUnknown
+}
+
+func (a Accessibility) MarshalText() ([]byte, error) {
+	return []byte(accessibilityNames[a]), nil
+}
+
+func (a *Accessibility) UnmarshalText(text []byte) error {
+	for accessibility, name := range accessibilityNames {
+		if name == string(text) {
+			*a = accessibility
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown wheelchair accessibility %q, expected yes, no or unknown", text)
+}
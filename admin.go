@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var adminToken = flag.String("admin-token", "", "shared secret for the admin endpoints, sent as \"Authorization: Bearer <token>\", they're disabled without it")
+
+// adminMux has the operational controls, served under /admin/ only when there's an admin token
+var adminMux = http.NewServeMux()
+
+func init() {
+	adminMux.HandleFunc("POST /admin/refresh-schedule", refreshScheduleHandler)
+}
+
+// isAdmin tells whether the request carries the admin token
+func isAdmin(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && *adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) == 1
+}
+
+// requireAdmin turns away requests without the admin token before they reach any admin endpoint,
+// so without the token it isn't even revealed which ones exist
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "A valid admin token is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refreshScheduleHandler checks for a new schedule and loads it right away instead of waiting for the next check,
+// for when ZET is known to have just published one
+func refreshScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if *noSchedule {
+		writeJSONError(w, http.StatusConflict, "The schedule is disabled with -no-schedule")
+		return
+	}
+
+	log.Printf("%s asked for a schedule refresh\n", r.RemoteAddr)
+	reloaded, err := scheduleCheck.check()
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	response := struct {
+		Reloaded        bool   `json:"reloaded"`
+		ScheduleVersion string `json:"schedule_version"`
+	}{
+		Reloaded:        reloaded,
+		ScheduleVersion: getScheduleVersion(),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshScheduleHandler(t *testing.T) {
+	upstream := startFakeUpstream(t)
+	setSchedule(t, schedule)
+	previous := scheduleCheck.upToDate.Load()
+	t.Cleanup(func() { scheduleCheck.upToDate.Store(previous) })
+	if err := loadSchedule(); err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		refreshScheduleHandler(recorder, httptest.NewRequest(http.MethodPost, "/admin/refresh-schedule", nil))
+		return recorder
+	}
+
+	// ZET publishes a new schedule
+	upstream.scheduleFilename.Store("attachment; filename=zet-gtfs-scheduled-000-00002.zip")
+	recorder := refresh()
+	var response struct {
+		Reloaded        bool   `json:"reloaded"`
+		ScheduleVersion string `json:"schedule_version"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("status %d: %v\n%s", recorder.Code, err, recorder.Body)
+	}
+	if recorder.Code != http.StatusOK || !response.Reloaded || response.ScheduleVersion != "000-00002" {
+		t.Errorf("status %d, %+v, want the new schedule 000-00002 reloaded", recorder.Code, response)
+	}
+
+	// the operator learns the server couldn't be asked rather than that nothing changed
+	upstream.scheduleDown.Store(true)
+	recorder = refresh()
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("with the schedule server down: status %d, want %d\n%s", recorder.Code, http.StatusBadGateway, recorder.Body)
+	}
+	var failure struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &failure); err != nil || failure.Error.Code != "bad_gateway" || failure.Error.Message == "" {
+		t.Errorf("error %s, want bad_gateway with a message", recorder.Body)
+	}
+}
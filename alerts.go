@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// the latest feed's entities carrying a service alert
+var serviceAlerts atomic.Value // []*gtfs.FeedEntity
+
+func getAlertEntities(feed *gtfs.FeedMessage) []*gtfs.FeedEntity {
+	entities := []*gtfs.FeedEntity{}
+	for _, entity := range feed.Entity {
+		if entity.Alert != nil {
+			entities = append(entities, entity)
+		}
+	}
+	return entities
+}
+
+type Alert struct {
+	ID          string    `json:"id"`
+	Header      string    `json:"header"`
+	Description string    `json:"description"`
+	URL         string    `json:"url,omitempty"`
+	Routes      []RouteID `json:"routes"`
+	Stops       []StopID  `json:"stops"`
+}
+
+// translate picks the Croatian text, or the one without a language, or else whichever comes first
+func translate(text *gtfs.TranslatedString) string {
+	translations := text.GetTranslation()
+	for _, language := range []string{"hr", ""} {
+		for _, translation := range translations {
+			if translation.GetLanguage() == language {
+				return translation.GetText()
+			}
+		}
+	}
+	if len(translations) > 0 {
+		return translations[0].GetText()
+	}
+	return ""
+}
+
+func simplifyAlert(entity *gtfs.FeedEntity) Alert {
+	alert := Alert{
+		ID:          entity.GetId(),
+		Header:      translate(entity.GetAlert().GetHeaderText()),
+		Description: translate(entity.GetAlert().GetDescriptionText()),
+		URL:         translate(entity.GetAlert().GetUrl()),
+		Routes:      []RouteID{},
+		Stops:       []StopID{},
+	}
+	for _, selector := range entity.GetAlert().GetInformedEntity() {
+		if selector.RouteId != nil {
+			alert.Routes = append(alert.Routes, RouteID(selector.GetRouteId()))
+		}
+		if selector.StopId != nil {
+			alert.Stops = append(alert.Stops, StopID(selector.GetStopId()))
+		}
+	}
+	return alert
+}
+
+// alertsHandler serves the service alerts simplified, or as the feed's own entities with ?format=gtfs
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	entities := serviceAlerts.Load().([]*gtfs.FeedEntity)
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		alerts := make([]Alert, len(entities))
+		for i, entity := range entities {
+			alerts[i] = simplifyAlert(entity)
+		}
+		response := struct {
+			Alerts []Alert `json:"alerts"`
+		}{
+			Alerts: alerts,
+		}
+
+		w.Header().Set("Content-Type", contentTypeJSON)
+		newJSONEncoder(w, r).Encode(response)
+
+	case "gtfs":
+		// protojson follows the GTFS Realtime field names, encoding/json wouldn't
+		alerts := make([]json.RawMessage, len(entities))
+		for i, entity := range entities {
+			data, err := protojson.Marshal(entity)
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			alerts[i] = data
+		}
+		response := struct {
+			Alerts []json.RawMessage `json:"alerts"`
+		}{
+			Alerts: alerts,
+		}
+
+		w.Header().Set("Content-Type", contentTypeJSON)
+		newJSONEncoder(w, r).Encode(response)
+
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("format must be gtfs or left out, got %q", format))
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+)
+
+type Anomaly struct {
+	RouteID   RouteID `json:"route_id"`
+	VehicleID string  `json:"vehicle_id"`
+	Headsign  string  `json:"headsign"`
+	// meters between the reported position and the trip's shape
+	DistanceFromShape Distance `json:"distance_from_shape"`
+}
+
+// findAnomalies returns the vehicles which are off their trip's shape
+func findAnomalies(routes map[RouteID]Vehicles) []Anomaly {
+	anomalies := []Anomaly{}
+	for _, routeID := range slices.SortedFunc(maps.Keys(routes), compareRouteIDs) {
+		for _, v := range routes[routeID] {
+			if !v.OffRoute {
+				continue
+			}
+			anomalies = append(anomalies, Anomaly{
+				RouteID:           routeID,
+				VehicleID:         v.ID,
+				Headsign:          v.Headsign,
+				DistanceFromShape: Distance(v.distanceFromShape),
+			})
+		}
+	}
+	return anomalies
+}
+
+func anomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Threshold Distance  `json:"threshold"`
+		Anomalies []Anomaly `json:"anomalies"`
+		Units     Units     `json:"units"`
+	}{
+		Units:     apiUnits(),
+		Threshold: Distance(getTunables().OffRouteThreshold),
+		Anomalies: findAnomalies(allVehicles.Load().(*Snapshot).Routes),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+type Arrival struct {
+	TripID         TripID  `json:"trip_id"`
+	RouteID        RouteID `json:"route_id"`
+	RouteShortName string  `json:"route_short_name"`
+	Headsign       string  `json:"headsign"`
+	Time           int64   `json:"time"` // predicted arrival, unix epoch
+	TimeISO        string  `json:"time_iso"`
+}
+
+// predicted arrivals from the latest feed's trip updates, sorted by time
+var stopArrivals atomic.Value // map[StopID][]Arrival
+
+const (
+	defaultArrivalsLimit = 10
+	maxArrivalsLimit     = 100
+)
+
+func getArrivals(feed *gtfs.FeedMessage) map[StopID][]Arrival {
+	arrivals := map[StopID][]Arrival{}
+	for _, entity := range feed.Entity {
+		tripUpdate := entity.GetTripUpdate()
+		if tripUpdate == nil {
+			continue
+		}
+
+		routeID := RouteID(tripUpdate.GetTrip().GetRouteId())
+		tripID := TripID(tripUpdate.GetTrip().GetTripId())
+		trip, _ := getTrip(routeID, tripID)
+		route, _ := getRoute(routeID)
+
+		for _, update := range tripUpdate.GetStopTimeUpdate() {
+			predicted, ok := predictedTime(tripUpdate, update)
+			if !ok {
+				continue
+			}
+
+			stopID := StopID(update.GetStopId())
+			if stopID == "" {
+				if scheduled, exists := getScheduledStopTime(tripID, update); exists {
+					stopID = scheduled.StopID
+				}
+			}
+			arrivals[stopID] = append(arrivals[stopID], Arrival{
+				TripID:         tripID,
+				RouteID:        routeID,
+				RouteShortName: route.ShortName,
+				Headsign:       getHeadsign(routeID, trip),
+				Time:           predicted,
+				TimeISO:        formatZagrebTime(predicted),
+			})
+		}
+	}
+
+	for _, stopArrivals := range arrivals {
+		slices.SortFunc(stopArrivals, func(a, b Arrival) int { return int(a.Time - b.Time) })
+	}
+	return arrivals
+}
+
+// getStopTimeEvent returns the arrival prediction, or the departure one if there's nothing about the arrival
+func getStopTimeEvent(update *gtfs.TripUpdate_StopTimeUpdate) *gtfs.TripUpdate_StopTimeEvent {
+	if arrival := update.GetArrival(); arrival.Time != nil || arrival.Delay != nil {
+		return arrival
+	}
+	return update.GetDeparture()
+}
+
+// getTripServiceDayStart returns the start of the service day the trip started on,
+// if the feed doesn't say, it's the one placing the scheduled time closest to the predicted one
+func getTripServiceDayStart(tripUpdate *gtfs.TripUpdate, scheduled int, predicted int64) (time.Time, error) {
+	if startDate := tripUpdate.GetTrip().GetStartDate(); startDate != "" {
+		return serviceDayStart(startDate)
+	}
+
+	predictedDay := time.Unix(predicted, 0).In(zagreb)
+	today, err := serviceDayStart(predictedDay.Format("20060102"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	yesterday, err := serviceDayStart(predictedDay.AddDate(0, 0, -1).Format("20060102"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	distance := func(start time.Time) int64 {
+		d := predicted - (start.Unix() + int64(scheduled))
+		return max(d, -d)
+	}
+	if distance(yesterday) < distance(today) {
+		return yesterday, nil
+	}
+	return today, nil
+}
+
+// predictedTime returns the predicted unix time of the update, adding the delay to the schedule if the feed has no absolute time
+func predictedTime(tripUpdate *gtfs.TripUpdate, update *gtfs.TripUpdate_StopTimeUpdate) (int64, bool) {
+	event := getStopTimeEvent(update)
+	if event.GetTime() != 0 {
+		return event.GetTime(), true
+	}
+	if event.Delay == nil {
+		return 0, false
+	}
+
+	scheduled, exists := getScheduledStopTime(TripID(tripUpdate.GetTrip().GetTripId()), update)
+	if !exists {
+		return 0, false
+	}
+
+	scheduledTime := scheduled.Arrival
+	if event == update.GetDeparture() {
+		scheduledTime = scheduled.Departure
+	}
+
+	// without a start date, the delayed stop is assumed to be around now, so trips past midnight land on the previous service day
+	start, err := getTripServiceDayStart(tripUpdate, scheduledTime+int(event.GetDelay()), time.Now().Unix())
+	if err != nil {
+		return 0, false
+	}
+	return start.Unix() + int64(scheduledTime) + int64(event.GetDelay()), true
+}
+
+// getTripDelay returns how many seconds late (or early, if negative) the trip is at its next stop
+func getTripDelay(tripUpdate *gtfs.TripUpdate, now time.Time) (int32, bool) {
+	updates := tripUpdate.GetStopTimeUpdate()
+	if len(updates) == 0 {
+		return tripUpdate.GetDelay(), tripUpdate.Delay != nil
+	}
+
+	// the first stop the trip hasn't reached yet, or the last one if it's all in the past
+	update := updates[len(updates)-1]
+	for _, u := range updates {
+		if predicted, ok := predictedTime(tripUpdate, u); ok && predicted >= now.Unix() {
+			update = u
+			break
+		}
+	}
+
+	event := getStopTimeEvent(update)
+	if event.Delay != nil {
+		return event.GetDelay(), true
+	}
+	if event.GetTime() == 0 {
+		return 0, false
+	}
+
+	scheduled, exists := getScheduledStopTime(TripID(tripUpdate.GetTrip().GetTripId()), update)
+	if !exists {
+		return 0, false
+	}
+	scheduledTime := scheduled.Arrival
+	if event == update.GetDeparture() {
+		scheduledTime = scheduled.Departure
+	}
+
+	start, err := getTripServiceDayStart(tripUpdate, scheduledTime, event.GetTime())
+	if err != nil {
+		return 0, false
+	}
+	return int32(event.GetTime() - (start.Unix() + int64(scheduledTime))), true
+}
+
+func arrivalsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r.URL.Query(), "limit", defaultArrivalsLimit, 1, maxArrivalsLimit)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().Unix()
+	upcoming := []Arrival{}
+	for _, arrival := range stopArrivals.Load().(map[StopID][]Arrival)[StopID(r.PathValue("stop_id"))] {
+		if len(upcoming) == limit {
+			break
+		}
+		if arrival.Time >= now {
+			upcoming = append(upcoming, arrival)
+		}
+	}
+
+	response := struct {
+		Arrivals []Arrival `json:"arrivals"`
+	}{
+		Arrivals: upcoming,
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+)
+
+// delayOnlyUpdate is a trip update without a start date, its one stop only has a delay
+func delayOnlyUpdate(tripID TripID, delay int32) *gtfs.TripUpdate {
+	return &gtfs.TripUpdate{
+		Trip: &gtfs.TripDescriptor{TripId: proto.String(string(tripID))},
+		StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{{
+			StopSequence: proto.Uint32(1),
+			Arrival:      &gtfs.TripUpdate_StopTimeEvent{Delay: proto.Int32(delay)},
+		}},
+	}
+}
+
+func TestPredictedTimeWithoutStartDate(t *testing.T) {
+	now := time.Now().In(zagreb)
+	today, err := serviceDayStart(now.Format("20060102"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday, err := serviceDayStart(now.AddDate(0, 0, -1).Format("20060102"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		dayStart time.Time
+	}{
+		{"today", today},
+		// a trip running past midnight, its stop times are over 24h into yesterday's service day
+		{"past midnight", yesterday},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const delay = 90
+			scheduled := int(now.Unix()-tt.dayStart.Unix()) - delay
+			setSchedule(t, &Schedule{StopTimes: map[TripID][]StopTime{
+				"0_1_101": {{Sequence: 1, StopID: "100_1", Arrival: scheduled, Departure: scheduled}},
+			}})
+
+			tripUpdate := delayOnlyUpdate("0_1_101", delay)
+			predicted, ok := predictedTime(tripUpdate, tripUpdate.StopTimeUpdate[0])
+			if !ok {
+				t.Fatal("no prediction")
+			}
+			if predicted != now.Unix() {
+				t.Errorf("predicted %s, want %s", time.Unix(predicted, 0).In(zagreb), now)
+			}
+		})
+	}
+}
+
+func TestGetTripDelayDelayOnly(t *testing.T) {
+	now := time.Now().In(zagreb)
+	today, err := serviceDayStart(now.Format("20060102"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinceStart := int(now.Unix() - today.Unix())
+	setSchedule(t, &Schedule{StopTimes: map[TripID][]StopTime{
+		"0_1_101": {
+			{Sequence: 1, StopID: "100_1", Arrival: sinceStart - 600, Departure: sinceStart - 600},
+			{Sequence: 2, StopID: "101_1", Arrival: sinceStart + 600, Departure: sinceStart + 600},
+			{Sequence: 3, StopID: "102_1", Arrival: sinceStart + 1200, Departure: sinceStart + 1200},
+		},
+	}})
+
+	tripUpdate := &gtfs.TripUpdate{
+		Trip: &gtfs.TripDescriptor{TripId: proto.String("0_1_101")},
+		StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{
+			{StopSequence: proto.Uint32(1), Arrival: &gtfs.TripUpdate_StopTimeEvent{Delay: proto.Int32(30)}},
+			{StopSequence: proto.Uint32(2), Arrival: &gtfs.TripUpdate_StopTimeEvent{Delay: proto.Int32(120)}},
+			{StopSequence: proto.Uint32(3), Arrival: &gtfs.TripUpdate_StopTimeEvent{Delay: proto.Int32(180)}},
+		},
+	}
+	delay, ok := getTripDelay(tripUpdate, now)
+	if !ok {
+		t.Fatal("no delay")
+	}
+	// the first stop is already behind the trip, the delay is the one at the next stop
+	if delay != 120 {
+		t.Errorf("delay %d, want 120", delay)
+	}
+}
+
+func TestGetArrivalsHeadsignFallback(t *testing.T) {
+	setSchedule(t, &Schedule{
+		Trips: RoutesToTrips{"6": {
+			"0_1_601": {Headsign: "Sopot"},
+			"0_1_602": {ShortName: "Črnomerec"},
+			"0_1_603": {},
+		}},
+		Routes: map[RouteID]Route{"6": {ID: "6", ShortName: "6", LongName: "Črnomerec - Sopot"}},
+	})
+
+	arrivalAt := func(tripID TripID, at int64) *gtfs.FeedEntity {
+		return &gtfs.FeedEntity{
+			Id: proto.String(string(tripID)),
+			TripUpdate: &gtfs.TripUpdate{
+				Trip: &gtfs.TripDescriptor{TripId: proto.String(string(tripID)), RouteId: proto.String("6")},
+				StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{{
+					StopId:  proto.String("100_1"),
+					Arrival: &gtfs.TripUpdate_StopTimeEvent{Time: proto.Int64(at)},
+				}},
+			},
+		}
+	}
+	feed := &gtfs.FeedMessage{Entity: []*gtfs.FeedEntity{
+		arrivalAt("0_1_603", 1300),
+		arrivalAt("0_1_601", 1100),
+		arrivalAt("0_1_602", 1200),
+	}}
+
+	got := []string{}
+	for _, arrival := range getArrivals(feed)["100_1"] {
+		got = append(got, arrival.Headsign)
+	}
+	want := []string{"Sopot", "Črnomerec", "Črnomerec - Sopot"}
+	if !slices.Equal(got, want) {
+		t.Errorf("headsigns %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+var faviconPath = flag.String("favicon", "data/favicon-32x32.png", "favicon to serve, the built-in one is used if it doesn't exist")
+var indexPath = flag.String("index", "index.html", "map page to serve, the built-in one is used if it doesn't exist")
+
+//go:embed index.html
+var embeddedIndex []byte
+
+//go:embed data/favicon-32x32.png
+var embeddedFavicon []byte
+
+// staticAsset is a file served from disk, falling back to a copy built into the binary
+type staticAsset struct {
+	path     string
+	embedded []byte
+	// whether the file on disk was missing at startup
+	useEmbedded bool
+}
+
+func newStaticAsset(path string, embedded []byte) *staticAsset {
+	asset := &staticAsset{path: path, embedded: embedded}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		log.Printf("Warning: %s does not exist, serving the built-in one instead\n", path)
+		asset.useEmbedded = true
+	}
+	return asset
+}
+
+func (a *staticAsset) serve(w http.ResponseWriter, r *http.Request) {
+	if a.useEmbedded {
+		http.ServeContent(w, r, a.path, startTime, bytes.NewReader(a.embedded))
+		return
+	}
+	http.ServeFile(w, r, a.path)
+}
+
+func (a *staticAsset) read() ([]byte, error) {
+	if a.useEmbedded {
+		return a.embedded, nil
+	}
+	return os.ReadFile(a.path)
+}
+
+var favicon *staticAsset
+
+// the map page gets the frontend config injected, so it's parsed once at startup
+var indexTemplate *template.Template
+
+func parseIndexTemplate(asset *staticAsset) (*template.Template, error) {
+	data, err := asset.read()
+	if err != nil {
+		return nil, err
+	}
+	return template.New("index").Parse(string(data))
+}
+
+// Zagreb's city center by default, another city's feed would want its own
+var mapCenterLat = flag.Float64("map-center-lat", 45.8020, "latitude the map opens at")
+var mapCenterLon = flag.Float64("map-center-lon", 15.9819, "longitude the map opens at")
+var mapZoom = flag.Int("map-zoom", 13, "zoom level the map opens at")
+
+func getMapCenter() Point {
+	return Point{Lat: *mapCenterLat, Lon: *mapCenterLon}
+}
+
+// validateMapView rejects a map view Leaflet couldn't show
+func validateMapView() error {
+	if *mapCenterLat < -90 || *mapCenterLat > 90 || *mapCenterLon < -180 || *mapCenterLon > 180 {
+		return fmt.Errorf("The map center has to be a valid coordinate, got %v, %v", *mapCenterLat, *mapCenterLon)
+	}
+	if *mapZoom < 0 || *mapZoom > 19 {
+		return fmt.Errorf("The map zoom has to be between 0 and 19, got %d", *mapZoom)
+	}
+	return nil
+}
+
+// FrontendConfig is what the map page needs to know about the deployment
+type FrontendConfig struct {
+	EventsPath     string `json:"events_path"`
+	PollIntervalMs int64  `json:"poll_interval_ms"`
+	MapCenter      Point  `json:"map_center"`
+	MapZoom        int    `json:"map_zoom"`
+	Units          string `json:"units"`
+}
+
+func getFrontendConfig() FrontendConfig {
+	return FrontendConfig{
+		EventsPath:     *ssePath,
+		PollIntervalMs: time.Duration(getTunables().PollInterval).Milliseconds(),
+		MapCenter:      getMapCenter(),
+		MapZoom:        *mapZoom,
+		Units:          *unitsName,
+	}
+}
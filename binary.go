@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"unicode/utf8"
+)
+
+// binaryFormatVersion is bumped whenever the layout below changes
+const binaryFormatVersion = 2
+
+// encodeBinarySnapshot packs the snapshot into the compact layout SSE clients get with ?encoding=binary,
+// sent base64 encoded in the event's data. Everything is little endian:
+//
+//	u8   format version, 2
+//	u8   flags, bit 0 is set when the feed is stale
+//	u64  feed timestamp, unix seconds
+//	u64  snapshot sequence, a gap since the previous one means updates were missed
+//	u16  number of routes, then for each route:
+//	  u8   length of the route ID, followed by the ID in UTF-8
+//	  u16  number of vehicles, then for each vehicle:
+//	    u8   length of the vehicle ID, followed by the ID in UTF-8
+//	    f32  latitude
+//	    f32  longitude
+//	    u16  bearing in degrees
+//
+// Routes are in route ID order and vehicles in vehicle ID order, like in the JSON.
+func encodeBinarySnapshot(s *Snapshot, stale bool) []byte {
+	var flags byte
+	if stale {
+		flags |= 1
+	}
+
+	data := make([]byte, 0, 20+len(s.Routes)*8+s.TotalVehicles*16)
+	data = append(data, binaryFormatVersion, flags)
+	data = binary.LittleEndian.AppendUint64(data, s.Timestamp)
+	data = binary.LittleEndian.AppendUint64(data, s.Sequence)
+
+	routeIDs := make([]RouteID, 0, len(s.Routes))
+	for routeID := range s.Routes {
+		routeIDs = append(routeIDs, routeID)
+	}
+	slices.Sort(routeIDs)
+
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(routeIDs)))
+	for _, routeID := range routeIDs {
+		data = appendShortString(data, string(routeID))
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(s.Routes[routeID])))
+		for _, v := range s.Routes[routeID] {
+			data = appendShortString(data, v.ID)
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v.Latitude))
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v.Longitude))
+			data = binary.LittleEndian.AppendUint16(data, uint16(v.Direction))
+		}
+	}
+	return data
+}
+
+// appendShortString appends the string prefixed with its length, IDs longer than 255 bytes are cut short
+// at the last whole character that fits, so the result is still valid UTF-8
+func appendShortString(data []byte, s string) []byte {
+	if len(s) > math.MaxUint8 {
+		n := math.MaxUint8
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n]
+	}
+	data = append(data, byte(len(s)))
+	return append(data, s...)
+}
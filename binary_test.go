@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// binaryReader decodes the layout encodeBinarySnapshot documents, the way a client would
+type binaryReader struct {
+	t    *testing.T
+	data []byte
+}
+
+func (r *binaryReader) next(n int) []byte {
+	r.t.Helper()
+	if len(r.data) < n {
+		r.t.Fatalf("wanted %d more bytes, only %d left", n, len(r.data))
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *binaryReader) u8() byte    { return r.next(1)[0] }
+func (r *binaryReader) u16() uint16 { return binary.LittleEndian.Uint16(r.next(2)) }
+func (r *binaryReader) u64() uint64 { return binary.LittleEndian.Uint64(r.next(8)) }
+func (r *binaryReader) f32() float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(r.next(4)))
+}
+func (r *binaryReader) string() string { return string(r.next(int(r.u8()))) }
+
+func TestEncodeBinarySnapshot(t *testing.T) {
+	snapshot := newSnapshot(map[RouteID]Vehicles{
+		"6":   {{ID: "a", Latitude: 45.8, Longitude: 15.97, Direction: 90}, {ID: "b", Latitude: 45.81, Longitude: 15.98, Direction: 359}},
+		"268": {{ID: "čž", Latitude: 45.7, Longitude: 16.05}},
+	}, 1_700_000_000)
+	snapshot.Sequence = 42
+
+	r := &binaryReader{t: t, data: encodeBinarySnapshot(snapshot, true)}
+	if version := r.u8(); version != binaryFormatVersion {
+		t.Fatalf("version %d, want %d", version, binaryFormatVersion)
+	}
+	if flags := r.u8(); flags != 1 {
+		t.Errorf("flags %b, want the stale bit", flags)
+	}
+	if timestamp := r.u64(); timestamp != snapshot.Timestamp {
+		t.Errorf("timestamp %d, want %d", timestamp, snapshot.Timestamp)
+	}
+	if sequence := r.u64(); sequence != snapshot.Sequence {
+		t.Errorf("sequence %d, want %d", sequence, snapshot.Sequence)
+	}
+
+	type vehicle struct {
+		id       string
+		lat, lon float32
+		bearing  uint16
+	}
+	decoded := map[string][]vehicle{}
+	routeOrder := []string{}
+	for range r.u16() {
+		routeID := r.string()
+		routeOrder = append(routeOrder, routeID)
+		for range r.u16() {
+			decoded[routeID] = append(decoded[routeID], vehicle{id: r.string(), lat: r.f32(), lon: r.f32(), bearing: r.u16()})
+		}
+	}
+	if len(r.data) != 0 {
+		t.Errorf("%d bytes left over", len(r.data))
+	}
+
+	if want := []string{"268", "6"}; !reflect.DeepEqual(routeOrder, want) {
+		t.Errorf("routes in order %v, want %v", routeOrder, want)
+	}
+	want := map[string][]vehicle{
+		"6":   {{"a", 45.8, 15.97, 90}, {"b", 45.81, 15.98, 359}},
+		"268": {{"čž", 45.7, 16.05, 0}},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded %v, want %v", decoded, want)
+	}
+}
+
+func TestAppendShortString(t *testing.T) {
+	long := string(make([]byte, 300))
+	if got := appendShortString(nil, long); got[0] != math.MaxUint8 || len(got) != 1+math.MaxUint8 {
+		t.Errorf("a 300 byte string was encoded as %d bytes with length %d", len(got), got[0])
+	}
+
+	// "č" is two bytes, 128 of them don't fit in 255 bytes and the one cut in half is left out
+	got := appendShortString(nil, strings.Repeat("č", 128))
+	if got[0] != 254 || len(got) != 1+254 || !utf8.Valid(got[1:]) {
+		t.Errorf("128 two byte characters were encoded as %d bytes with length %d, valid UTF-8: %t", len(got), got[0], utf8.Valid(got[1:]))
+	}
+}
+
+// syntheticFleet is n vehicles with realistic IDs, positions and headsigns spread over the routes
+func syntheticFleet(routes, n int) *Snapshot {
+	fleet := map[RouteID]Vehicles{}
+	for i := range n {
+		routeID := RouteID(strconv.Itoa(1 + i%routes))
+		fleet[routeID] = append(fleet[routeID], Vehicle{
+			ID:        strconv.Itoa(10000 + i),
+			Latitude:  45.75 + float32(i%97)*0.0011,
+			Longitude: 15.85 + float32(i%89)*0.0023,
+			Headsign:  "Črnomerec",
+			Direction: i * 37 % 360,
+		})
+	}
+	return newSnapshot(fleet, 1_700_000_000)
+}
+
+// BenchmarkSnapshotPayload reports the size of a snapshot in each encoding, the binary one is meant to beat
+// even JSON cut down to the same fields with coordinates rounded to 5 decimals (about a meter)
+func BenchmarkSnapshotPayload(b *testing.B) {
+	snapshot := syntheticFleet(60, 320)
+
+	type roundedVehicle struct {
+		ID        string  `json:"id"`
+		Lat       float64 `json:"lat"`
+		Lon       float64 `json:"lon"`
+		Direction int     `json:"direction"`
+	}
+	round := func(x float32) float64 { return math.Round(float64(x)*1e5) / 1e5 }
+
+	encodings := []struct {
+		name   string
+		encode func() []byte
+	}{
+		{"json", func() []byte {
+			data, _ := json.Marshal(snapshot.Routes)
+			return data
+		}},
+		{"rounded json", func() []byte {
+			rounded := map[RouteID][]roundedVehicle{}
+			for routeID, vehicles := range snapshot.Routes {
+				for _, v := range vehicles {
+					rounded[routeID] = append(rounded[routeID], roundedVehicle{v.ID, round(v.Latitude), round(v.Longitude), v.Direction})
+				}
+			}
+			data, _ := json.Marshal(rounded)
+			return data
+		}},
+		{"binary", func() []byte { return encodeBinarySnapshot(snapshot, false) }},
+		{"binary base64", func() []byte {
+			return []byte(base64.StdEncoding.EncodeToString(encodeBinarySnapshot(snapshot, false)))
+		}},
+	}
+	for _, encoding := range encodings {
+		b.Run(encoding.name, func(b *testing.B) {
+			var size int
+			for b.Loop() {
+				size = len(encoding.encode())
+			}
+			b.ReportMetric(float64(size), "bytes/msg")
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// getStopList returns every stop in the schedule, ordered by ID
+func getStopList() []Stop {
+	mu.RLock()
+	stops := make([]Stop, 0, len(schedule.Stops))
+	for _, stop := range schedule.Stops {
+		stops = append(stops, stop)
+	}
+	mu.RUnlock()
+
+	slices.SortFunc(stops, func(a, b Stop) int { return strings.Compare(string(a.ID), string(b.ID)) })
+	return stops
+}
+
+// bootstrapHandler returns everything a client needs to start in a single round trip,
+// after which it can follow the SSE stream, ?stops=0 leaves out the stops which are the bulk of it
+func bootstrapHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := allVehicles.Load().(*Snapshot)
+
+	response := struct {
+		ScheduleVersion  string               `json:"schedule_version"`
+		ScheduleFilename string               `json:"schedule_filename"`
+		Routes           []RouteInfo          `json:"routes"`
+		Stops            []Stop               `json:"stops,omitempty"`
+		Vehicles         map[RouteID]Vehicles `json:"vehicles"`
+		TotalVehicles    int                  `json:"total_vehicles"`
+		TotalRoutes      int                  `json:"total_routes"`
+		Timestamp        uint64               `json:"timestamp"`
+		Units            Units                `json:"units"`
+	}{
+		Units:            apiUnits(),
+		ScheduleVersion:  getScheduleVersion(),
+		ScheduleFilename: getScheduleFilename(),
+		Routes:           getRouteInfos(),
+		Vehicles:         snapshot.Routes,
+		TotalVehicles:    snapshot.TotalVehicles,
+		TotalRoutes:      snapshot.TotalRoutes,
+		Timestamp:        snapshot.Timestamp,
+	}
+	if r.URL.Query().Get("stops") != "0" {
+		response.Stops = getStopList()
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+)
+
+// how many snapshots a client may lag behind before updates get dropped for it
+const clientBufferSize = 4
+
+// consecutive dropped updates after which a client is considered stuck and disconnected
+const maxClientDrops = 5
+
+type broadcastClient struct {
+	updates chan *Snapshot
+	drops   int
+	// closed when the client falls too far behind
+	tooSlow chan struct{}
+}
+
+// broadcaster fans out every new snapshot to the connected clients without ever waiting on them
+type broadcaster struct {
+	mu      sync.Mutex
+	clients map[*broadcastClient]struct{}
+}
+
+var vehicleBroadcaster = &broadcaster{clients: map[*broadcastClient]struct{}{}}
+
+func (b *broadcaster) subscribe() *broadcastClient {
+	client := &broadcastClient{
+		updates: make(chan *Snapshot, clientBufferSize),
+		tooSlow: make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients[client] = struct{}{}
+	return client
+}
+
+func (b *broadcaster) unsubscribe(client *broadcastClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, client)
+}
+
+func (b *broadcaster) publish(snapshot *Snapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for client := range b.clients {
+		select {
+		case client.updates <- snapshot:
+			client.drops = 0
+		default:
+			client.drops++
+			if client.drops == maxClientDrops {
+				close(client.tooSlow)
+				delete(b.clients, client)
+			}
+		}
+	}
+}
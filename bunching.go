@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+type BunchedPair struct {
+	DirectionID string   `json:"direction_id"`
+	Leading     string   `json:"leading"`
+	Following   string   `json:"following"`
+	Spacing     Distance `json:"spacing"`
+}
+
+// vehiclesByDirection groups the vehicles which have a position along their shape by direction,
+// ordered from the one furthest along the route
+func vehiclesByDirection(vehicles Vehicles) map[string]Vehicles {
+	byDirection := map[string]Vehicles{}
+	for _, v := range vehicles {
+		// without a shape there's no way to tell how far apart they are along the route
+		if v.Progress == nil {
+			continue
+		}
+		byDirection[v.directionID] = append(byDirection[v.directionID], v)
+	}
+
+	for _, directionVehicles := range byDirection {
+		slices.SortFunc(directionVehicles, func(a, b Vehicle) int {
+			switch {
+			case a.distanceAlong > b.distanceAlong:
+				return -1
+			case a.distanceAlong < b.distanceAlong:
+				return 1
+			}
+			return 0
+		})
+	}
+	return byDirection
+}
+
+// findBunching compares consecutive vehicles going in the same direction by how far along the route they are
+func findBunching(vehicles Vehicles, threshold float64) []BunchedPair {
+	pairs := []BunchedPair{}
+	for directionID, directionVehicles := range vehiclesByDirection(vehicles) {
+		for i := 1; i < len(directionVehicles); i++ {
+			leading, following := directionVehicles[i-1], directionVehicles[i]
+			if spacing := leading.distanceAlong - following.distanceAlong; spacing < threshold {
+				pairs = append(pairs, BunchedPair{
+					DirectionID: directionID,
+					Leading:     leading.ID,
+					Following:   following.ID,
+					Spacing:     Distance(spacing),
+				})
+			}
+		}
+	}
+	return pairs
+}
+
+func bunchingHandler(w http.ResponseWriter, r *http.Request) {
+	routeID := RouteID(r.PathValue("id"))
+	vehicles := allVehicles.Load().(*Snapshot).Routes[routeID]
+	threshold := getTunables().BunchingThreshold
+
+	response := struct {
+		RouteID   RouteID       `json:"route_id"`
+		Threshold Distance      `json:"threshold"`
+		Pairs     []BunchedPair `json:"pairs"`
+		Units     Units         `json:"units"`
+	}{
+		Units:     apiUnits(),
+		RouteID:   routeID,
+		Threshold: Distance(threshold),
+		Pairs:     findBunching(vehicles, threshold),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
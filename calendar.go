@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var filterServices = flag.Bool("filter-services", true, "keep only the trips whose service runs around the current day, per calendar.txt and calendar_dates.txt")
+
+// calendar_dates.txt exception types
+const (
+	serviceAdded   = "1"
+	serviceRemoved = "2"
+)
+
+type serviceCalendar struct {
+	// indexed by time.Weekday
+	weekdays  [7]bool
+	startDate string
+	endDate   string
+}
+
+// Calendar tells which services run on which days, dates are YYYYMMDD which compare correctly as strings
+type Calendar struct {
+	services map[string]serviceCalendar
+	// service ID to date to exception type
+	exceptions map[string]map[string]string
+}
+
+func parseCalendar(calendarData, datesData []byte) (Calendar, error) {
+	calendar := Calendar{services: map[string]serviceCalendar{}, exceptions: map[string]map[string]string{}}
+
+	// either file is optional as long as the other one is there
+	if calendarData != nil {
+		table, err := parseCSV(calendarData)
+		if err != nil {
+			return Calendar{}, err
+		}
+		days := []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+		for _, row := range table.rows {
+			service := serviceCalendar{startDate: table.get(row, "start_date"), endDate: table.get(row, "end_date")}
+			for weekday, column := range days {
+				service.weekdays[weekday] = table.get(row, column) == "1"
+			}
+			calendar.services[table.get(row, "service_id")] = service
+		}
+	}
+
+	if datesData != nil {
+		table, err := parseCSV(datesData)
+		if err != nil {
+			return Calendar{}, err
+		}
+		for _, row := range table.rows {
+			serviceID := table.get(row, "service_id")
+			if _, exists := calendar.exceptions[serviceID]; !exists {
+				calendar.exceptions[serviceID] = map[string]string{}
+			}
+			calendar.exceptions[serviceID][table.get(row, "date")] = table.get(row, "exception_type")
+		}
+	}
+
+	return calendar, nil
+}
+
+// isActive reports whether the service runs on the given day
+func (c Calendar) isActive(serviceID string, day time.Time) bool {
+	date := day.Format("20060102")
+	switch c.exceptions[serviceID][date] {
+	case serviceAdded:
+		return true
+	case serviceRemoved:
+		return false
+	}
+
+	service, exists := c.services[serviceID]
+	return exists && service.startDate <= date && date <= service.endDate && service.weekdays[day.Weekday()]
+}
+
+// filterActiveTrips keeps the trips whose service runs the day before, on or the day after the given service day,
+// so trips running past midnight and the next day's early trips still match until the schedule is reloaded
+func filterActiveTrips(trips RoutesToTrips, calendar Calendar, serviceDay string) (RoutesToTrips, error) {
+	day, err := time.ParseInLocation("20060102", serviceDay, zagreb)
+	if err != nil {
+		return nil, err
+	}
+	days := []time.Time{day.AddDate(0, 0, -1), day, day.AddDate(0, 0, 1)}
+
+	filtered := RoutesToTrips{}
+	for routeID, routeTrips := range trips {
+		for tripID, trip := range routeTrips {
+			for _, d := range days {
+				if calendar.isActive(trip.ServiceID, d) {
+					if _, exists := filtered[routeID]; !exists {
+						filtered[routeID] = Trips{}
+					}
+					filtered[routeID][tripID] = trip
+					break
+				}
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// isScheduleForAnotherDay reports whether the loaded trips were filtered for a service day other than the current one
+func isScheduleForAnotherDay() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return schedule.ServiceDay != "" && schedule.ServiceDay != getServiceDay(time.Now())
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var configPath = flag.String("config", "", "JSON file overriding the tunable settings, reloaded on SIGHUP")
+
+var pollInterval = flag.Duration("poll-interval", 2*time.Second, "how often the realtime feed is fetched")
+var snapToShape = flag.Bool("snap", false, "snap vehicle positions onto their trip's shape")
+var bunchingThreshold = flag.Float64("bunching-threshold", 300, "meters between consecutive vehicles on a route below which they are considered bunched")
+var bearingThreshold = flag.Float64("bearing-threshold", 3, "degrees a vehicle's bearing has to change by to be updated")
+var moveThreshold = flag.Float64("move-threshold", 1e-5, "degrees a vehicle has to move by for its bearing to be updated")
+var teleportThreshold = flag.Float64("teleport-threshold", 1000, "meters a vehicle can move between two updates before it's considered a GPS glitch")
+var offRouteThreshold = flag.Float64("off-route-threshold", 150, "meters from its trip's shape beyond which a vehicle is flagged as off route")
+var averageSpeed = flag.Float64("average-speed", 15, "km/h vehicles are assumed to travel at when estimating headways")
+var emptyFeedGrace = flag.Duration("empty-feed-grace", time.Minute, "how long the previous vehicles keep being served when the feed suddenly has none, 0 to never keep them")
+var maxVehicleAge = flag.Duration("max-vehicle-age", 5*time.Minute, "vehicles whose last report is older than this are dropped, 0 to keep them all")
+var geofenceWebhook = flag.String("geofence-webhook", "", "URL vehicles entering and leaving the config file's geofences are POSTed to")
+var minSnapshotMovement = flag.Float64("min-snapshot-movement", 0, "meters at least one vehicle has to move by for a new snapshot to be pushed to SSE and WebSocket clients, unlike -move-threshold it gates the whole snapshot, 0 to push every one")
+var maxRouteVehicles = flag.Int("max-vehicles-per-route", 0, "most vehicles a single route can have, a feed glitch going over it is cut down to the most recently reported ones, 0 for no limit")
+var maxSSEClients = flag.Int64("max-clients", 1000, "maximum number of concurrent SSE clients, 0 for no limit")
+
+// Duration is a time.Duration written as a string like "2s" in the config file
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Tunables are the settings which can be changed without a restart
+type Tunables struct {
+	PollInterval      Duration   `json:"poll_interval"`
+	SnapToShape       bool       `json:"snap"`
+	BunchingThreshold float64    `json:"bunching_threshold"`
+	BearingThreshold  float64    `json:"bearing_threshold"`
+	MoveThreshold     float64    `json:"move_threshold"`
+	MaxSSEClients     int64      `json:"max_clients"`
+	TeleportThreshold float64    `json:"teleport_threshold"`
+	OffRouteThreshold float64    `json:"off_route_threshold"`
+	AverageSpeed      float64    `json:"average_speed"`
+	EmptyFeedGrace    Duration   `json:"empty_feed_grace"`
+	MaxVehicleAge     Duration   `json:"max_vehicle_age"`
+	GeofenceWebhook   string     `json:"geofence_webhook"`
+	SnapshotMovement  float64    `json:"min_snapshot_movement"`
+	MaxRouteVehicles  int        `json:"max_vehicles_per_route"`
+	Geofences         []Geofence `json:"geofences"`
+	DirectionLabels   Directions `json:"direction_labels"`
+}
+
+var tunables atomic.Pointer[Tunables]
+
+func getTunables() *Tunables {
+	return tunables.Load()
+}
+
+func tunablesFromFlags() Tunables {
+	return Tunables{
+		PollInterval:      Duration(*pollInterval),
+		SnapToShape:       *snapToShape,
+		BunchingThreshold: *bunchingThreshold,
+		BearingThreshold:  *bearingThreshold,
+		MoveThreshold:     *moveThreshold,
+		MaxSSEClients:     *maxSSEClients,
+		TeleportThreshold: *teleportThreshold,
+		OffRouteThreshold: *offRouteThreshold,
+		AverageSpeed:      *averageSpeed,
+		EmptyFeedGrace:    Duration(*emptyFeedGrace),
+		MaxVehicleAge:     Duration(*maxVehicleAge),
+		GeofenceWebhook:   *geofenceWebhook,
+		SnapshotMovement:  *minSnapshotMovement,
+		MaxRouteVehicles:  *maxRouteVehicles,
+	}
+}
+
+// loadTunables reads the config file on top of the flags, settings missing from the file keep their flag value
+func loadTunables(path string) (*Tunables, error) {
+	result := tunablesFromFlags()
+	if path == "" {
+		return &result, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("Could not parse config file: %v", err)
+	}
+	if result.AverageSpeed <= 0 {
+		return nil, fmt.Errorf("average_speed must be positive, got %v", result.AverageSpeed)
+	}
+	if result.PollInterval <= 0 {
+		return nil, fmt.Errorf("poll_interval must be positive, got %v", time.Duration(result.PollInterval))
+	}
+	if err := result.DirectionLabels.validate(); err != nil {
+		return nil, err
+	}
+	for _, fence := range result.Geofences {
+		if err := fence.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// everything else (listen address, recording, ...) is only read from flags at startup
+	settings := map[string]json.RawMessage{}
+	json.Unmarshal(data, &settings)
+	known := map[string]bool{}
+	for _, field := range reflect.VisibleFields(reflect.TypeFor[Tunables]()) {
+		known[strings.Split(field.Tag.Get("json"), ",")[0]] = true
+	}
+	for name := range settings {
+		if !known[name] {
+			log.Printf("Ignoring %q from the config file, it can't be changed while running\n", name)
+		}
+	}
+
+	return &result, nil
+}
+
+// reloadConfigOnSIGHUP swaps in the config file's settings every time the process gets a SIGHUP,
+// an invalid file keeps the current settings
+func reloadConfigOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			reloaded, err := loadTunables(*configPath)
+			if err != nil {
+				log.Println("Could not reload the config, keeping the current one: ", err)
+				continue
+			}
+			tunables.Store(reloaded)
+			log.Printf("Reloaded config: %+v\n", *reloaded)
+		}
+	}()
+}
+
+// EffectiveConfig is everything the server is currently running with
+type EffectiveConfig struct {
+	RealtimeURL      string   `json:"realtime_url"`
+	ScheduleURL      string   `json:"schedule_url"`
+	NoSchedule       bool     `json:"no_schedule"`
+	Predicted        bool     `json:"predicted_vehicles"`
+	ScheduleFilename string   `json:"schedule_filename"`
+	ConfigFile       string   `json:"config_file"`
+	Tunables         Tunables `json:"tunables"`
+	CORSOrigins      []string `json:"cors_origins"`
+	SSEPath          string   `json:"sse_path"`
+	Units            string   `json:"units"`
+	UnixSocket       string   `json:"unix_socket"`
+	StaleAfter       Duration `json:"stale_after"`
+	Debug            bool     `json:"debug"`
+	Pprof            bool     `json:"pprof"`
+	ServiceArea      string   `json:"service_bbox"`
+	IncludeRoutes    string   `json:"include_routes"`
+	ExcludeRoutes    string   `json:"exclude_routes"`
+	DataDir          string   `json:"data_dir"`
+	RecordDir        string   `json:"record_dir"`
+	RecordKeep       int      `json:"record_keep"`
+	ReplayDir        string   `json:"replay_dir"`
+	ReplaySpeed      float64  `json:"replay_speed"`
+}
+
+func getEffectiveConfig() EffectiveConfig {
+	return EffectiveConfig{
+		RealtimeURL:      gtfsURL,
+		ScheduleURL:      tripsDataURL,
+		NoSchedule:       *noSchedule,
+		Predicted:        *servePredicted,
+		ScheduleFilename: getScheduleFilename(),
+		ConfigFile:       *configPath,
+		Tunables:         *getTunables(),
+		CORSOrigins:      []string{allowedOrigin},
+		SSEPath:          *ssePath,
+		Units:            *unitsName,
+		UnixSocket:       *unixSocket,
+		StaleAfter:       Duration(*staleAfter),
+		Debug:            *enableDebug,
+		Pprof:            *enablePprof,
+		IncludeRoutes:    *includeRoutes,
+		ExcludeRoutes:    *excludeRoutes,
+		ServiceArea:      serviceArea.String(),
+		DataDir:          *dataDir,
+		RecordDir:        *recordDir,
+		RecordKeep:       *recordKeep,
+		ReplayDir:        *replayDir,
+		ReplaySpeed:      *replaySpeed,
+	}
+}
+
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(getEffectiveConfig())
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var dataDir = flag.String("data-dir", "data", "directory everything the server writes goes into, relative -record, -replay and -autocert-cache paths are inside it")
+
+// dataPath resolves a relative path inside the data directory, absolute paths are left alone
+func dataPath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(*dataDir, path)
+}
+
+// setUpDataDir creates the data directory and points the flags of the files written at runtime into it
+func setUpDataDir() error {
+	if err := os.MkdirAll(*dataDir, 0o755); err != nil {
+		return fmt.Errorf("Could not create the data directory: %v", err)
+	}
+
+	*recordDir = dataPath(*recordDir)
+	*replayDir = dataPath(*replayDir)
+	*autocertCache = dataPath(*autocertCache)
+	return nil
+}
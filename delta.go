@@ -0,0 +1,71 @@
+package main
+
+import "slices"
+
+// Delta is what changed between two snapshots, a client applies it by replacing the updated vehicles
+// (by ID, wherever they were before) and deleting the removed ones.
+// Vehicles missing from a single feed are carried over (see carryOverVanished), so they aren't removed and re-added.
+type Delta struct {
+	// the client should throw away its state and take Updated as the whole fleet
+	Full      bool   `json:"full"`
+	Timestamp uint64 `json:"timestamp"`
+	// timestamp of the snapshot this delta applies on top of
+	BaseTimestamp uint64               `json:"base_timestamp,omitempty"`
+	Updated       map[RouteID]Vehicles `json:"updated"`
+	Removed       []string             `json:"removed"`
+	TotalVehicles int                  `json:"total_vehicles"`
+	TotalRoutes   int                  `json:"total_routes"`
+	Stale         bool                 `json:"stale"`
+	// the snapshot's sequence, a gap since the previous delta means updates were missed
+	Sequence uint64 `json:"sequence"`
+	Units    Units  `json:"units"`
+}
+
+// computeDelta returns the vehicles whose position, bearing or route changed, or everything when there's no previous snapshot
+func computeDelta(previous, current *Snapshot) Delta {
+	if previous == nil {
+		return Delta{
+			Full:          true,
+			Timestamp:     current.Timestamp,
+			Sequence:      current.Sequence,
+			Units:         apiUnits(),
+			Updated:       current.Routes,
+			Removed:       []string{},
+			TotalVehicles: current.TotalVehicles,
+			TotalRoutes:   current.TotalRoutes,
+		}
+	}
+
+	delta := Delta{
+		Timestamp:     current.Timestamp,
+		Sequence:      current.Sequence,
+		Units:         apiUnits(),
+		BaseTimestamp: previous.Timestamp,
+		Updated:       map[RouteID]Vehicles{},
+		Removed:       []string{},
+		TotalVehicles: current.TotalVehicles,
+		TotalRoutes:   current.TotalRoutes,
+	}
+
+	for routeID, vehicles := range current.Routes {
+		for _, v := range vehicles {
+			oldRouteID, old, exists := previous.getVehicle(v.ID)
+			changed := !exists ||
+				oldRouteID != routeID ||
+				old.Latitude != v.Latitude ||
+				old.Longitude != v.Longitude ||
+				old.Direction != v.Direction
+			if changed {
+				delta.Updated[routeID] = append(delta.Updated[routeID], v)
+			}
+		}
+	}
+
+	for id := range previous.index {
+		if _, exists := current.index[id]; !exists {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+	slices.Sort(delta.Removed)
+	return delta
+}
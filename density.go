@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+)
+
+const (
+	defaultCellSize = 500 // meters
+	minCellSize     = 50
+	maxCellSize     = 10000
+)
+
+type DensityCell struct {
+	Center   Point `json:"center"`
+	Vehicles int   `json:"vehicles"`
+}
+
+type cellKey struct {
+	x, y int64
+}
+
+// the grid is an equirectangular projection around the map center, which is plenty accurate at city scale
+var metersPerDegreeLat = earthRadius * math.Pi / 180
+
+// calculateDensity buckets the vehicles into square cells of the given size in meters
+func calculateDensity(routes map[RouteID]Vehicles, cellSize float64) []DensityCell {
+	metersPerDegreeLon := metersPerDegreeLat * math.Cos(getMapCenter().Lat*math.Pi/180)
+	counts := map[cellKey]int{}
+	for _, vehicles := range routes {
+		for _, v := range vehicles {
+			key := cellKey{
+				x: int64(math.Floor(float64(v.Longitude) * metersPerDegreeLon / cellSize)),
+				y: int64(math.Floor(float64(v.Latitude) * metersPerDegreeLat / cellSize)),
+			}
+			counts[key]++
+		}
+	}
+
+	cells := make([]DensityCell, 0, len(counts))
+	for key, count := range counts {
+		cells = append(cells, DensityCell{
+			Center: Point{
+				Lat: (float64(key.y) + 0.5) * cellSize / metersPerDegreeLat,
+				Lon: (float64(key.x) + 0.5) * cellSize / metersPerDegreeLon,
+			},
+			Vehicles: count,
+		})
+	}
+
+	// busiest first, then by position so the output is stable
+	slices.SortFunc(cells, func(a, b DensityCell) int {
+		if a.Vehicles != b.Vehicles {
+			return b.Vehicles - a.Vehicles
+		}
+		if a.Center.Lat != b.Center.Lat {
+			return int(math.Copysign(1, a.Center.Lat-b.Center.Lat))
+		}
+		return int(math.Copysign(1, a.Center.Lon-b.Center.Lon))
+	})
+	return cells
+}
+
+func densityHandler(w http.ResponseWriter, r *http.Request) {
+	cellSize, err := queryFloat(r.URL.Query(), "cellSize", defaultCellSize, minCellSize, maxCellSize)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := struct {
+		CellSize float64       `json:"cell_size"`
+		Cells    []DensityCell `json:"cells"`
+	}{
+		CellSize: cellSize,
+		Cells:    calculateDensity(allVehicles.Load().(*Snapshot).Routes, cellSize),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
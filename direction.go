@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+// what direction_id 0 and 1 mean unless the config file says otherwise for the route,
+// the GTFS spec leaves it to the agency, but 0 is usually the trip away from the center
+var defaultDirectionLabels = [2]string{"outbound", "inbound"}
+
+// Directions maps a route to the labels of its direction_id 0 and 1, in the config file as
+// {"6": ["to Sopot", "to Črnomerec"]}
+type Directions map[RouteID][2]string
+
+func (labels Directions) validate() error {
+	for routeID, names := range labels {
+		if names[0] == "" || names[1] == "" {
+			return fmt.Errorf("Direction labels of route %q can't be empty", routeID)
+		}
+	}
+	return nil
+}
+
+// getDirectionLabel names the trip's direction_id for riders, taken from the schedule or else the feed,
+// empty when neither has one
+func getDirectionLabel(routeID RouteID, descriptor *gtfs.TripDescriptor, trip Trip) string {
+	direction, err := strconv.Atoi(trip.Direction)
+	if err != nil && descriptor.DirectionId != nil {
+		direction, err = int(descriptor.GetDirectionId()), nil
+	}
+	if err != nil || direction < 0 || direction > 1 {
+		return ""
+	}
+	if names, exists := getTunables().DirectionLabels[routeID]; exists {
+		return names[direction]
+	}
+	return defaultDirectionLabels[direction]
+}
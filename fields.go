@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// vehicleFields are the JSON names of the vehicle fields ?fields= can pick from
+var vehicleFields = func() map[string]bool {
+	fields := map[string]bool{}
+	for _, field := range reflect.VisibleFields(reflect.TypeFor[Vehicle]()) {
+		if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// parseFields returns the requested vehicle fields, nil when the client wants all of them,
+// along with the ones that don't exist
+func parseFields(value string) (fields []string, unknown []string) {
+	if value == "" {
+		return nil, nil
+	}
+	fields = []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if vehicleFields[name] {
+			fields = append(fields, name)
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	return fields, unknown
+}
+
+// warnUnknownFields tells the client which of the requested fields were ignored, without failing the request
+func warnUnknownFields(w http.ResponseWriter, unknown []string) {
+	if len(unknown) > 0 {
+		w.Header().Set("Warning", fmt.Sprintf(`299 - "Unknown fields ignored: %s"`, strings.Join(unknown, ", ")))
+	}
+}
+
+// projectVehicles keeps only the given fields of each vehicle, fields a vehicle omits stay omitted
+func projectVehicles(routes map[RouteID]Vehicles, fields []string) map[RouteID][]map[string]json.RawMessage {
+	projected := make(map[RouteID][]map[string]json.RawMessage, len(routes))
+	for routeID, vehicles := range routes {
+		projectedVehicles := make([]map[string]json.RawMessage, 0, len(vehicles))
+		for _, v := range vehicles {
+			data, _ := json.Marshal(v)
+			all := map[string]json.RawMessage{}
+			json.Unmarshal(data, &all)
+
+			selected := make(map[string]json.RawMessage, len(fields))
+			for _, name := range fields {
+				if value, exists := all[name]; exists {
+					selected[name] = value
+				}
+			}
+			projectedVehicles = append(projectedVehicles, selected)
+		}
+		projected[routeID] = projectedVehicles
+	}
+	return projected
+}
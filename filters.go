@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// vehicleFilter decides whether a vehicle should be part of a response
+type vehicleFilter func(routeID RouteID, v Vehicle) bool
+
+var routeTypesByName = map[string]int{
+	"tram": routeTypeTram,
+	"bus":  routeTypeBus,
+}
+
+func parseVehicleFilters(query url.Values) ([]vehicleFilter, error) {
+	filters := []vehicleFilter{}
+
+	// comma separated route IDs, /routes/{id}/vehicles is the same for a single route with its details
+	if routes := parseRouteList(query.Get("route")); routes != nil {
+		filters = append(filters, func(routeID RouteID, v Vehicle) bool {
+			return routes[string(routeID)]
+		})
+	}
+
+	if typeName := query.Get("type"); typeName != "" {
+		routeType, exists := routeTypesByName[typeName]
+		if !exists {
+			return nil, fmt.Errorf("Unknown vehicle type %q, expected tram or bus", typeName)
+		}
+		filters = append(filters, func(routeID RouteID, v Vehicle) bool {
+			route, exists := getRoute(routeID)
+			return exists && route.Type == routeType
+		})
+	}
+
+	if headsign := query.Get("headsign"); headsign != "" {
+		needle := foldCroatian(headsign)
+		filters = append(filters, func(routeID RouteID, v Vehicle) bool {
+			return strings.Contains(foldCroatian(v.Headsign), needle)
+		})
+	}
+
+	if wheelchair := query.Get("wheelchair"); wheelchair != "" {
+		var accessibility Accessibility
+		if err := accessibility.UnmarshalText([]byte(wheelchair)); err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(routeID RouteID, v Vehicle) bool {
+			return v.WheelchairAccessible == accessibility
+		})
+	}
+
+	// in km/h whatever the -units, vehicles whose speed isn't known yet stay in
+	if query.Get("minSpeed") != "" {
+		minSpeed, err := queryFloat(query, "minSpeed", 0, 0, 1000)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, func(routeID RouteID, v Vehicle) bool {
+			return v.Speed == nil || float64(*v.Speed) >= minSpeed
+		})
+	}
+
+	return filters, nil
+}
+
+var croatianFolder = strings.NewReplacer("č", "c", "ć", "c", "š", "s", "ž", "z", "đ", "d")
+
+// foldCroatian lowercases the text and strips Croatian diacritics, so "crnomerec" matches "Črnomerec"
+func foldCroatian(s string) string {
+	return croatianFolder.Replace(strings.ToLower(s))
+}
+
+// applyFilters returns only the vehicles passing every filter, leaving out routes which end up empty
+func applyFilters(routes map[RouteID]Vehicles, filters []vehicleFilter) map[RouteID]Vehicles {
+	if len(filters) == 0 {
+		return routes
+	}
+
+	filtered := map[RouteID]Vehicles{}
+	for routeID, vehicles := range routes {
+		for _, v := range vehicles {
+			passes := true
+			for _, filter := range filters {
+				if !filter(routeID, v) {
+					passes = false
+					break
+				}
+			}
+			if passes {
+				filtered[routeID] = append(filtered[routeID], v)
+			}
+		}
+	}
+	return filtered
+}
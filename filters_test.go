@@ -0,0 +1,90 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestMinSpeedFilter(t *testing.T) {
+	speed := func(kmh Speed) *Speed { return &kmh }
+	routes := map[RouteID]Vehicles{
+		"6":  {{ID: "standing", Speed: speed(0)}, {ID: "crawling", Speed: speed(4.9)}, {ID: "at the limit", Speed: speed(5)}},
+		"14": {{ID: "cruising", Speed: speed(32)}, {ID: "just seen"}},
+		"17": {{ID: "stuck", Speed: speed(1)}},
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"at the limit", "crawling", "cruising", "just seen", "standing", "stuck"}},
+		{"minSpeed=0", []string{"at the limit", "crawling", "cruising", "just seen", "standing", "stuck"}},
+		{"minSpeed=5", []string{"at the limit", "cruising", "just seen"}},
+		{"minSpeed=50", []string{"just seen"}},
+	}
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filters, err := parseVehicleFilters(query)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+
+		got := []string{}
+		for _, vehicles := range applyFilters(routes, filters) {
+			for _, v := range vehicles {
+				got = append(got, v.ID)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	if filtered := applyFilters(routes, []vehicleFilter{}); len(filtered) != len(routes) {
+		t.Errorf("no filters left out routes: %v", filtered)
+	}
+	for _, invalid := range []string{"NaN", "-1", "fast"} {
+		if _, err := parseVehicleFilters(url.Values{"minSpeed": {invalid}}); err == nil {
+			t.Errorf("minSpeed=%s: no error", invalid)
+		}
+	}
+
+	filters, _ := parseVehicleFilters(url.Values{"minSpeed": {"10"}})
+	if _, exists := applyFilters(routes, filters)["17"]; exists {
+		t.Errorf("route 17 is kept without any vehicle passing")
+	}
+}
+
+func TestRouteFilter(t *testing.T) {
+	routes := map[RouteID]Vehicles{
+		"6":  {{ID: "601"}, {ID: "602"}},
+		"14": {{ID: "1401"}},
+		"17": {{ID: "1701"}},
+	}
+
+	tests := []struct {
+		route string
+		want  []RouteID
+	}{
+		{"", []RouteID{"14", "17", "6"}},
+		{"6", []RouteID{"6"}},
+		{"6, 17", []RouteID{"17", "6"}},
+		{"31", []RouteID{}},
+	}
+	for _, tt := range tests {
+		filters, err := parseVehicleFilters(url.Values{"route": {tt.route}})
+		if err != nil {
+			t.Fatalf("route=%q: %v", tt.route, err)
+		}
+		got := slices.Sorted(maps.Keys(applyFilters(routes, filters)))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("route=%q: got routes %v, want %v", tt.route, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// Frequency is a window in which a frequency-based trip departs every HeadwaySeconds
+type Frequency struct {
+	// seconds since the start of the service day, like StopTime's
+	Start          int `json:"start"`
+	End            int `json:"end"`
+	HeadwaySeconds int `json:"headway_seconds"`
+	// whether departures are at exact times or only roughly that often
+	ExactTimes bool `json:"exact_times"`
+}
+
+func parseFrequencies(data []byte) (map[TripID][]Frequency, error) {
+	table, err := parseCSV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[TripID][]Frequency{}
+	for _, row := range table.rows {
+		start, errStart := parseGTFSTime(table.get(row, "start_time"))
+		end, errEnd := parseGTFSTime(table.get(row, "end_time"))
+		headway, errHeadway := strconv.Atoi(table.get(row, "headway_secs"))
+		if errStart != nil || errEnd != nil || errHeadway != nil || headway <= 0 {
+			continue
+		}
+
+		tripID := TripID(table.get(row, "trip_id"))
+		result[tripID] = append(result[tripID], Frequency{
+			Start:          start,
+			End:            end,
+			HeadwaySeconds: headway,
+			ExactTimes:     table.get(row, "exact_times") == "1",
+		})
+	}
+	return result, nil
+}
+
+// getScheduledHeadway returns the headway of the trip's frequency window covering now,
+// trips running past midnight belong to the previous service day, so that one is checked too
+func getScheduledHeadway(trip Trip, now time.Time) (int, bool) {
+	if len(trip.Frequencies) == 0 {
+		return 0, false
+	}
+
+	today := now.In(zagreb)
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		start, err := serviceDayStart(day.Format("20060102"))
+		if err != nil {
+			continue
+		}
+		seconds := int(now.Sub(start).Seconds())
+		for _, frequency := range trip.Frequencies {
+			if frequency.Start <= seconds && seconds < frequency.End {
+				return frequency.HeadwaySeconds, true
+			}
+		}
+	}
+	return 0, false
+}
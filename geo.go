@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+)
+
+var unitsName = flag.String("units", "metric", "units of the distances and speeds in API responses, metric (meters, km/h) or imperial (feet, mph), each response names them in its units field")
+
+// earthModel measures distances on the Earth's surface, all the geo math goes through it so the model can be swapped
+type earthModel interface {
+	// distance returns the distance between two points in meters
+	distance(p1, p2 Point) float64
+}
+
+const earthRadius = 6371000 // meters
+
+// haversine treats the Earth as a sphere, within a fraction of a percent of the ellipsoid at city scale
+type haversine struct{}
+
+func (haversine) distance(p1, p2 Point) float64 {
+	lat1 := p1.Lat * math.Pi / 180
+	lat2 := p2.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (p2.Lon - p1.Lon) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+var earth earthModel = haversine{}
+
+// geoDistance returns the distance between two points in meters
+func geoDistance(p1, p2 Point) float64 {
+	return earth.distance(p1, p2)
+}
+
+// Units says what the distances and speeds of a response are in, since the field names don't
+type Units struct {
+	Distance string `json:"distance"`
+	Speed    string `json:"speed"`
+}
+
+// unitSystem converts the internal meters and km/h for API responses
+type unitSystem struct {
+	perMeter float64
+	perKmh   float64
+	label    Units
+}
+
+var unitSystems = map[string]unitSystem{
+	"metric":   {perMeter: 1, perKmh: 1, label: Units{Distance: "m", Speed: "km/h"}},
+	"imperial": {perMeter: 1 / 0.3048, perKmh: 1 / 1.609344, label: Units{Distance: "ft", Speed: "mph"}},
+}
+
+var units = unitSystems["metric"]
+
+func setUnits(name string) error {
+	system, exists := unitSystems[name]
+	if !exists {
+		return fmt.Errorf("Unknown units %q, expected metric or imperial", name)
+	}
+	units = system
+	return nil
+}
+
+// apiUnits is the units field of every response with a Distance or a Speed in it
+func apiUnits() Units {
+	return units.label
+}
+
+// Distance is in meters, it's written in the configured units that apiUnits names
+type Distance float64
+
+func (d Distance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(float64(d) * units.perMeter)
+}
+
+// Speed is in km/h, it's written in the configured units that apiUnits names
+type Speed float64
+
+func (s Speed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(float64(s) * units.perKmh)
+}
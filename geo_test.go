@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// flatEarth is a stand-in model, a degree is a kilometer in every direction
+type flatEarth struct{}
+
+func (flatEarth) distance(p1, p2 Point) float64 {
+	return 1000 * math.Hypot(p2.Lat-p1.Lat, p2.Lon-p1.Lon)
+}
+
+func TestEarthModelSwappable(t *testing.T) {
+	p1, p2 := Point{Lat: 45.8, Lon: 15.9}, Point{Lat: 45.83, Lon: 15.94}
+	if got := geoDistance(p1, p2); math.Abs(got-4554) > 1 {
+		t.Errorf("haversine distance %.1fm, want about 4554m", got)
+	}
+
+	previous := earth
+	earth = flatEarth{}
+	t.Cleanup(func() { earth = previous })
+	if got := geoDistance(p1, p2); math.Abs(got-50) > 1e-9 {
+		t.Errorf("distance %.1fm, want the model's 50m", got)
+	}
+}
+
+func TestSetUnits(t *testing.T) {
+	t.Cleanup(func() { setUnits("metric") })
+
+	marshal := func(v any) string {
+		t.Helper()
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	distances := struct {
+		Odometer Distance `json:"odometer"`
+		Speed    Speed    `json:"speed"`
+	}{Odometer: 1609.344, Speed: 100}
+
+	if err := setUnits("metric"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := marshal(distances), `{"odometer":1609.344,"speed":100}`; got != want {
+		t.Errorf("metric: %s, want %s", got, want)
+	}
+
+	if err := setUnits("imperial"); err != nil {
+		t.Fatal(err)
+	}
+	var imperial struct {
+		Odometer float64 `json:"odometer"`
+		Speed    float64 `json:"speed"`
+	}
+	if err := json.Unmarshal([]byte(marshal(distances)), &imperial); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(imperial.Odometer-5280) > 1e-6 || math.Abs(imperial.Speed-62.137) > 1e-3 {
+		t.Errorf("imperial: %+v, want 5280 feet and 62.137 mph", imperial)
+	}
+	if got, want := apiUnits(), (Units{Distance: "ft", Speed: "mph"}); got != want {
+		t.Errorf("imperial units: %+v, want %+v", got, want)
+	}
+
+	for _, name := range []string{"", "Metric", "km"} {
+		if err := setUnits(name); err == nil {
+			t.Errorf("%q: no error", name)
+		}
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	tests := []struct {
+		name   string
+		p1, p2 Point
+		want   float64 // meters
+	}{
+		{"same point", jelacicSquare, jelacicSquare, 0},
+		{"Jelačić square to the main station", jelacicSquare, mainStation, 905.5},
+		{"Jelačić square to the cathedral", jelacicSquare, cathedral, 421.1},
+		// a degree of latitude is the same everywhere, a degree of longitude shrinks with the latitude
+		{"a degree of latitude", Point{Lat: 45.8, Lon: 15.9}, Point{Lat: 46.8, Lon: 15.9}, 111195},
+		{"a tenth of a degree of longitude", Point{Lat: 45.8, Lon: 15.9}, Point{Lat: 45.8, Lon: 16}, 7752},
+		{"across the antimeridian", Point{Lat: 0, Lon: 179.9}, Point{Lat: 0, Lon: -179.9}, 22239},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := geoDistance(tt.p1, tt.p2); math.Abs(got-tt.want) > 1 {
+				t.Errorf("distance %.1fm, want %.1fm", got, tt.want)
+			}
+			if back := geoDistance(tt.p2, tt.p1); math.Abs(back-geoDistance(tt.p1, tt.p2)) > 1e-6 {
+				t.Errorf("distance back %.1fm differs", back)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Geofence is an area operators want to know about vehicles entering and leaving,
+// its polygon is a list of [lon, lat] pairs like in GeoJSON, closing it is optional
+type Geofence struct {
+	Name    string       `json:"name"`
+	Polygon [][2]float64 `json:"polygon"`
+}
+
+func (g Geofence) validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("Geofences need a name")
+	}
+	if len(g.Polygon) < 3 {
+		return fmt.Errorf("Geofence %q needs at least 3 points, got %d", g.Name, len(g.Polygon))
+	}
+	return nil
+}
+
+// contains is the ray casting test, counting how many edges a ray going east from the point crosses
+func (g Geofence) contains(p Point) bool {
+	inside := false
+	for i, j := 0, len(g.Polygon)-1; i < len(g.Polygon); j, i = i, i+1 {
+		lonI, latI := g.Polygon[i][0], g.Polygon[i][1]
+		lonJ, latJ := g.Polygon[j][0], g.Polygon[j][1]
+		if (latI > p.Lat) != (latJ > p.Lat) && p.Lon < lonI+(p.Lat-latI)/(latJ-latI)*(lonJ-lonI) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// how many consecutive feeds a vehicle has to be on the other side of a fence's boundary for it to count,
+// so GPS jitter along the boundary doesn't fire enter and exit over and over
+const geofenceDebounceTicks = 2
+
+const (
+	geofenceWebhookAttempts = 3
+	geofenceWebhookTimeout  = 10 * time.Second
+)
+
+type GeofenceEvent struct {
+	Geofence  string  `json:"geofence"`
+	Event     string  `json:"event"` // enter or exit
+	VehicleID string  `json:"vehicle_id"`
+	RouteID   RouteID `json:"route_id"`
+	Lat       float32 `json:"lat"`
+	Lon       float32 `json:"lon"`
+	Timestamp uint64  `json:"timestamp"` // feed timestamp, unix epoch
+}
+
+type geofenceState struct {
+	inside bool
+	// consecutive feeds the vehicle has been on the other side
+	pending int
+}
+
+// per fence and vehicle, only touched from the goroutine polling the feed
+var geofenceStates = map[string]map[string]*geofenceState{}
+
+// checkGeofences compares the snapshot against the vehicles' previous sides of every fence and
+// sends the transitions to the webhook, a vehicle's first sighting only records its side
+func checkGeofences(snapshot *Snapshot) {
+	settings := getTunables()
+	if settings.GeofenceWebhook == "" || len(settings.Geofences) == 0 {
+		return
+	}
+
+	events := []GeofenceEvent{}
+	states := map[string]map[string]*geofenceState{}
+	for _, fence := range settings.Geofences {
+		previous := geofenceStates[fence.Name]
+		current := map[string]*geofenceState{}
+		for routeID, vehicles := range snapshot.Routes {
+			for _, v := range vehicles {
+				inside := fence.contains(Point{Lat: float64(v.Latitude), Lon: float64(v.Longitude)})
+				state, exists := previous[v.ID]
+				if !exists {
+					current[v.ID] = &geofenceState{inside: inside}
+					continue
+				}
+				current[v.ID] = state
+
+				if inside == state.inside {
+					state.pending = 0
+					continue
+				}
+				state.pending++
+				if state.pending < geofenceDebounceTicks {
+					continue
+				}
+
+				state.inside, state.pending = inside, 0
+				event := GeofenceEvent{
+					Geofence:  fence.Name,
+					Event:     "exit",
+					VehicleID: v.ID,
+					RouteID:   routeID,
+					Lat:       v.Latitude,
+					Lon:       v.Longitude,
+					Timestamp: snapshot.Timestamp,
+				}
+				if inside {
+					event.Event = "enter"
+				}
+				events = append(events, event)
+			}
+		}
+		// vehicles which left the feed and removed fences are forgotten
+		states[fence.Name] = current
+	}
+	geofenceStates = states
+
+	if len(events) > 0 {
+		go sendGeofenceEvents(settings.GeofenceWebhook, events)
+	}
+}
+
+// sendGeofenceEvents posts the events to the webhook, retrying a couple of times before giving up on them
+func sendGeofenceEvents(url string, events []GeofenceEvent) {
+	payload, _ := json.Marshal(struct {
+		Events []GeofenceEvent `json:"events"`
+	}{
+		Events: events,
+	})
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := postGeofenceEvents(url, payload)
+		if err == nil {
+			return
+		}
+		if attempt == geofenceWebhookAttempts {
+			log.Printf("Dropping %d geofence events after %d failed attempts: %v\n", len(events), attempt, err)
+			return
+		}
+		log.Printf("Could not deliver geofence events, retrying in %v: %v\n", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postGeofenceEvents(url string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), geofenceWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Geometry struct {
+	Type        string    `json:"type"`
+	Coordinates []float32 `json:"coordinates"` // longitude first, as GeoJSON wants it
+}
+
+// FeatureProperties include simplestyle hints so the features render sensibly without a custom style
+type FeatureProperties struct {
+	RouteID RouteID `json:"route_id"`
+	Vehicle
+	MarkerColor  string `json:"marker-color,omitempty"`
+	MarkerSymbol string `json:"marker-symbol,omitempty"`
+	// degrees clockwise from north
+	Rotation int `json:"rotation"`
+}
+
+type Feature struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id"`
+	Geometry   Geometry          `json:"geometry"`
+	Properties FeatureProperties `json:"properties"`
+}
+
+// FeatureCollection is what writeFeatureCollection streams
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// routeFeatures returns the route's vehicles as GeoJSON points
+func routeFeatures(routeID RouteID, vehicles Vehicles) []Feature {
+	route, _ := getRoute(routeID)
+	markerColor := ""
+	if route.Color != "" {
+		markerColor = "#" + route.Color
+	}
+
+	features := make([]Feature, 0, len(vehicles))
+	for _, v := range vehicles {
+		features = append(features, Feature{
+			Type: "Feature",
+			ID:   v.ID,
+			Geometry: Geometry{
+				Type:        "Point",
+				Coordinates: []float32{v.Longitude, v.Latitude},
+			},
+			Properties: FeatureProperties{
+				RouteID:      routeID,
+				Vehicle:      v,
+				MarkerColor:  markerColor,
+				MarkerSymbol: markerSymbols[route.Type],
+				// the bearing is counterclockwise from east
+				Rotation: ((90-v.Direction)%360 + 360) % 360,
+			},
+		})
+	}
+	return features
+}
+
+// writeFeatureCollection streams the vehicles as a FeatureCollection a route at a time,
+// so the whole fleet's features are never held at once
+func writeFeatureCollection(w http.ResponseWriter, r *http.Request, routes map[RouteID]Vehicles) {
+	stream := newJSONStream(w, r)
+	stream.raw("{")
+	stream.key("type", 1)
+	stream.value("FeatureCollection", "")
+	stream.raw(",")
+	stream.key("features", 1)
+	stream.raw("[")
+
+	first := true
+	for _, routeID := range slices.SortedFunc(maps.Keys(routes), compareRouteIDs) {
+		for _, feature := range routeFeatures(routeID, routes[routeID]) {
+			if !first {
+				stream.raw(",")
+			}
+			first = false
+			stream.raw(stream.newline + stream.prefix(2))
+			stream.value(feature, stream.prefix(2))
+		}
+		if err := stream.flush(); err != nil {
+			// it's cut off either way
+			log.Println("Stopped streaming GeoJSON: ", err)
+			return
+		}
+	}
+	if !first {
+		stream.raw(stream.newline + stream.prefix(1))
+	}
+	stream.raw("]" + stream.newline + "}\n")
+	stream.flush()
+}
+
+// Maki icon names, as used by simplestyle
+var markerSymbols = map[int]string{
+	routeTypeTram: "rail-light",
+	routeTypeBus:  "bus",
+}
+
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeGeoJSON = "application/geo+json"
+)
+
+// newJSONEncoder writes compact JSON unless the request asks for ?pretty=1, for reading it by hand
+func newJSONEncoder(w http.ResponseWriter, r *http.Request) *json.Encoder {
+	// the encoder writes each value at once, so a value over the limit is refused as a whole
+	encoder := json.NewEncoder(newCappedWriter(w))
+	if r.URL.Query().Get("pretty") == "1" {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder
+}
+
+// negotiateContentType picks the offer the Accept header prefers the most,
+// the first offer wins ties and is the default when nothing matches
+func negotiateContentType(accept string, offers ...string) string {
+	best, bestQuality := offers[0], 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(mediaRange), ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, found := strings.CutPrefix(strings.TrimSpace(param), "q="); found {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		for _, offer := range offers {
+			// wildcards don't express a preference, so they leave the default in place
+			if mediaType == offer && quality > bestQuality {
+				best, bestQuality = offer, quality
+			}
+		}
+	}
+	return best
+}
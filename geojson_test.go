@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// the streamed collection is byte for byte what encoding it in one go would give
+func TestWriteFeatureCollection(t *testing.T) {
+	setSchedule(t, &Schedule{Routes: map[RouteID]Route{
+		"6":   {ID: "6", ShortName: "6", Type: routeTypeTram, Color: "1264AB"},
+		"268": {ID: "268", ShortName: "268", Type: routeTypeBus},
+	}})
+
+	fleets := map[string]map[RouteID]Vehicles{
+		"empty": {},
+		"fleet": {
+			"268": {{ID: "26801", Latitude: 45.78, Longitude: 16.05, Headsign: "Velika Gorica", Direction: 180}},
+			"6":   {{ID: "601", Latitude: 45.81, Longitude: 15.97, Headsign: "Sopot"}, {ID: "602", Latitude: 45.8, Longitude: 15.96, Direction: 90}},
+		},
+	}
+	for name, routes := range fleets {
+		want := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+		for _, routeID := range []RouteID{"6", "268"} {
+			want.Features = append(want.Features, routeFeatures(routeID, routes[routeID])...)
+		}
+
+		for _, target := range []string{"/vehicles", "/vehicles?pretty=1"} {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, target, nil)
+			writeFeatureCollection(recorder, request, routes)
+
+			var encoded bytes.Buffer
+			encoder := json.NewEncoder(&encoded)
+			if request.URL.Query().Get("pretty") == "1" {
+				encoder.SetIndent("", "  ")
+			}
+			encoder.Encode(want)
+			if recorder.Body.String() != encoded.String() {
+				t.Errorf("%s %s: streamed\n%s\nwant\n%s", name, target, recorder.Body, &encoded)
+			}
+		}
+	}
+}
+
+func TestWriteFeatureCollectionMaxResponseSize(t *testing.T) {
+	setSchedule(t, &Schedule{})
+	previous := *maxResponseSize
+	*maxResponseSize = 64
+	t.Cleanup(func() { *maxResponseSize = previous })
+
+	recorder := httptest.NewRecorder()
+	writeFeatureCollection(recorder, httptest.NewRequest(http.MethodGet, "/vehicles", nil), map[RouteID]Vehicles{"6": {{ID: "601"}}})
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+}
@@ -3,6 +3,14 @@ module github.com/mark2185/zet
 go 1.24.1
 
 require (
-	github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs v1.0.0 // indirect
-	google.golang.org/protobuf v1.36.6 // indirect
+	github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs v1.0.0
+	github.com/coder/websocket v1.8.15
+	golang.org/x/crypto v0.48.0
+	golang.org/x/time v0.14.0
+	google.golang.org/protobuf v1.36.6
+)
+
+require (
+	golang.org/x/net v0.49.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
 )
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+)
+
+var updateFixtures = flag.Bool("update-fixtures", false, "regenerate the feed and schedule fixtures in testdata/")
+
+const fixtureTimestamp = 1_700_000_000
+
+// the schedule the fixtures' vehicles run on, route 6 runs west and route 14 north
+var fixtureSchedule = map[string]string{
+	"routes.txt": `route_id,route_short_name,route_long_name,route_type,route_color
+6,6,Črnomerec - Sopot,0,1264AB
+14,14,Mihaljevac - Zapruđe,0,1264AB
+`,
+	"trips.txt": `route_id,service_id,trip_id,trip_headsign,direction_id,shape_id
+6,0_1,0_1_601,Črnomerec,1,6_1
+14,0_1,0_1_1401,Mihaljevac,0,14_1
+`,
+	"stops.txt": `stop_id,stop_name,stop_lat,stop_lon
+100_1,Trg bana Jelačića,45.8131,15.9772
+101_1,Glavni kolodvor,45.8050,15.9784
+`,
+	"shapes.txt": `shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence
+6_1,45.8131,15.9800,1
+6_1,45.8131,15.9700,2
+14_1,45.8000,15.9900,1
+14_1,45.8100,15.9900,2
+`,
+	"stop_times.txt": `trip_id,arrival_time,departure_time,stop_id,stop_sequence
+0_1_601,08:00:00,08:00:00,101_1,1
+0_1_601,08:05:00,08:05:00,100_1,2
+0_1_1401,08:00:00,08:00:00,101_1,1
+0_1_1401,08:06:00,08:06:00,100_1,2
+`,
+}
+
+func fixtureVehicle(id, routeID, tripID string, lat, lon float32, timestamp uint64) *gtfs.FeedEntity {
+	return &gtfs.FeedEntity{
+		Id: proto.String(id),
+		Vehicle: &gtfs.VehiclePosition{
+			Trip:      &gtfs.TripDescriptor{TripId: proto.String(tripID), RouteId: proto.String(routeID)},
+			Vehicle:   &gtfs.VehicleDescriptor{Id: proto.String(id)},
+			Position:  &gtfs.Position{Latitude: proto.Float32(lat), Longitude: proto.Float32(lon)},
+			Timestamp: proto.Uint64(timestamp),
+		},
+	}
+}
+
+// fixtureFeeds are two successive feeds, the second one has a trip the schedule doesn't know
+func fixtureFeeds() []*gtfs.FeedMessage {
+	header := func(timestamp uint64) *gtfs.FeedHeader {
+		return &gtfs.FeedHeader{GtfsRealtimeVersion: proto.String("2.0"), Timestamp: proto.Uint64(timestamp)}
+	}
+	return []*gtfs.FeedMessage{
+		{
+			Header: header(fixtureTimestamp),
+			Entity: []*gtfs.FeedEntity{
+				fixtureVehicle("101", "6", "0_1_601", 45.8131, 15.9780, fixtureTimestamp),
+				fixtureVehicle("201", "14", "0_1_1401", 45.8020, 15.9900, fixtureTimestamp),
+			},
+		},
+		{
+			Header: header(fixtureTimestamp + 10),
+			Entity: []*gtfs.FeedEntity{
+				fixtureVehicle("101", "6", "0_1_601", 45.8131, 15.9760, fixtureTimestamp+10),
+				fixtureVehicle("201", "14", "0_1_1401", 45.8040, 15.9900, fixtureTimestamp+10),
+				fixtureVehicle("202", "14", "0_1_1499", 45.8060, 15.9900, fixtureTimestamp+10),
+			},
+		},
+	}
+}
+
+func writeFixtures(t testing.TB) {
+	for i, feed := range fixtureFeeds() {
+		data, err := proto.Marshal(feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join("testdata", fmt.Sprintf("feed-%d.pb", i+1)), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	// sorted and without timestamps, so regenerating the same schedule gives the same bytes
+	for _, name := range slices.Sorted(maps.Keys(fixtureSchedule)) {
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(fixtureSchedule[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("testdata", "schedule.zip"), archive.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFixture(t testing.TB, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("%v, regenerate the fixtures with -update-fixtures", err)
+	}
+	return data
+}
+
+// fakeUpstream stands in for ZET, serving the fixture feeds one poll at a time and the fixture schedule
+type fakeUpstream struct {
+	feeds    [][]byte
+	polls    atomic.Int64
+	schedule []byte
+	// the schedule's Content-Disposition, changing it publishes a new schedule
+	scheduleFilename atomic.Value // string
+	// GET requests for the schedule, HEAD ones only check it
+	scheduleDownloads atomic.Int64
+	// the schedule server answers every request with a 503 while it's down
+	scheduleDown atomic.Bool
+}
+
+func (u *fakeUpstream) serveFeed(w http.ResponseWriter, r *http.Request) {
+	i := min(int(u.polls.Add(1))-1, len(u.feeds)-1)
+	w.Write(u.feeds[i])
+}
+
+func (u *fakeUpstream) serveSchedule(w http.ResponseWriter, r *http.Request) {
+	if u.scheduleDown.Load() {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method == http.MethodGet {
+		u.scheduleDownloads.Add(1)
+	}
+	w.Header().Set("Content-Disposition", u.scheduleFilename.Load().(string))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Write(u.schedule)
+}
+
+// startFakeUpstream points the feed and schedule URLs at a fake ZET for the duration of the test
+func startFakeUpstream(t *testing.T) *fakeUpstream {
+	if *updateFixtures {
+		writeFixtures(t)
+	}
+
+	upstream := &fakeUpstream{
+		feeds:    [][]byte{readFixture(t, "feed-1.pb"), readFixture(t, "feed-2.pb")},
+		schedule: readFixture(t, "schedule.zip"),
+	}
+	upstream.scheduleFilename.Store("attachment; filename=zet-gtfs-scheduled-000-00001.zip")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /gtfs-rt-protobuf", upstream.serveFeed)
+	mux.HandleFunc("/gtfs-scheduled/latest", upstream.serveSchedule)
+	server := httptest.NewServer(mux)
+
+	previousFeedURL, previousScheduleURL, previousDataDir := gtfsURL, tripsDataURL, *dataDir
+	gtfsURL = server.URL + "/gtfs-rt-protobuf"
+	tripsDataURL = server.URL + "/gtfs-scheduled/latest"
+	*dataDir = t.TempDir()
+	t.Cleanup(func() {
+		server.Close()
+		gtfsURL, tripsDataURL, *dataDir = previousFeedURL, previousScheduleURL, previousDataDir
+	})
+	return upstream
+}
+
+func TestPollAgainstFakeUpstream(t *testing.T) {
+	upstream := startFakeUpstream(t)
+
+	// everything a poll touches is put back for the other tests
+	setSchedule(t, schedule)
+	previousSnapshot := allVehicles.Load().(*Snapshot)
+	t.Cleanup(func() {
+		allVehicles.Store(previousSnapshot)
+		atomic.StoreUint64(&lastUpdateTimestamp, 0)
+		clear(missingTicks)
+	})
+	select {
+	case <-scheduleCheck.checkNow:
+	default:
+	}
+
+	if err := loadSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	p := &poller{source: liveFeed{url: gtfsURL}, lastPublished: allVehicles.Load().(*Snapshot)}
+
+	type vehicleSummary struct {
+		Headsign  string `json:"headsign"`
+		Direction int    `json:"direction"`
+	}
+	getVehicles := func() map[RouteID]map[string]vehicleSummary {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		vehicleHandler(recorder, httptest.NewRequest(http.MethodGet, "/vehicles", nil))
+		var response struct {
+			Vehicles map[RouteID][]struct {
+				ID string `json:"id"`
+				vehicleSummary
+			} `json:"vehicles"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("/vehicles isn't JSON: %v\n%s", err, recorder.Body)
+		}
+		vehicles := map[RouteID]map[string]vehicleSummary{}
+		for routeID, routeVehicles := range response.Vehicles {
+			vehicles[routeID] = map[string]vehicleSummary{}
+			for _, v := range routeVehicles {
+				vehicles[routeID][v.ID] = v.vehicleSummary
+			}
+		}
+		return vehicles
+	}
+
+	if err := p.poll(); err != nil {
+		t.Fatal(err)
+	}
+	first := getVehicles()
+	if got := first["6"]["101"].Headsign; got != "Črnomerec" {
+		t.Errorf("first poll: vehicle 101's headsign is %q, want Črnomerec", got)
+	}
+	if got := first["14"]["201"].Headsign; got != "Mihaljevac" {
+		t.Errorf("first poll: vehicle 201's headsign is %q, want Mihaljevac", got)
+	}
+
+	if err := p.poll(); err != nil {
+		t.Fatal(err)
+	}
+	second := getVehicles()
+	// the bearing comes from the move between the two feeds
+	if got := second["6"]["101"].Direction; got != 180 {
+		t.Errorf("second poll: vehicle 101 heading west has direction %d, want 180", got)
+	}
+	if got := second["14"]["201"].Direction; got != 90 {
+		t.Errorf("second poll: vehicle 201 heading north has direction %d, want 90", got)
+	}
+	// a trip missing from the schedule still gets the route's name
+	if got := second["14"]["202"].Headsign; got != "Mihaljevac - Zapruđe" {
+		t.Errorf("second poll: vehicle 202 on an unknown trip has headsign %q, want the route's long name", got)
+	}
+
+	// the unknown trip asked for a schedule check, which finds the new schedule the server now has
+	select {
+	case <-scheduleCheck.checkNow:
+	default:
+		t.Fatal("an unknown trip didn't request a schedule check")
+	}
+	upstream.scheduleFilename.Store("attachment; filename=zet-gtfs-scheduled-000-00002.zip")
+	reloaded, err := scheduleCheck.check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded || getScheduleVersion() != "000-00002" {
+		t.Errorf("reloaded %v, schedule version %q, want the new schedule 000-00002", reloaded, getScheduleVersion())
+	}
+	if reloaded, err := scheduleCheck.check(); err != nil || reloaded {
+		t.Errorf("checking a current schedule reloaded %v, %v", reloaded, err)
+	}
+}
+
+// loadFixtureSchedule swaps in the fixture schedule for the duration of the test
+func loadFixtureSchedule(t testing.TB) {
+	t.Helper()
+	files := map[string][]byte{}
+	for name, data := range fixtureSchedule {
+		files[name] = []byte(data)
+	}
+	parsed, err := parseSchedule(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSchedule(t, parsed)
+}
+
+// fixtureFleet is n vehicles made from the second fixture feed's, each with its own ID and a slightly different position
+func fixtureFleet(t testing.TB, n int) []*gtfs.VehiclePosition {
+	t.Helper()
+	feed, err := parseGTFSRealTime(readFixture(t, "feed-2.pb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vehicles := make([]*gtfs.VehiclePosition, 0, n)
+	for i := range n {
+		v := proto.Clone(feed.Entity[i%len(feed.Entity)].Vehicle).(*gtfs.VehiclePosition)
+		v.Vehicle.Id = proto.String(fmt.Sprintf("%04d", i))
+		v.Position.Latitude = proto.Float32(v.Position.GetLatitude() + float32(i%100)*1e-4)
+		vehicles = append(vehicles, v)
+	}
+	return vehicles
+}
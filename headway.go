@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+type DirectionHeadway struct {
+	DirectionID string `json:"direction_id"`
+	Vehicles    int    `json:"vehicles"`
+	// nil when there are fewer than two vehicles to measure a gap between
+	AverageSeconds *float64 `json:"average_headway_seconds"`
+	MinSeconds     *float64 `json:"min_headway_seconds"`
+	MaxSeconds     *float64 `json:"max_headway_seconds"`
+	// from frequencies.txt, nil unless the direction's trips are frequency-based
+	ScheduledSeconds *int `json:"scheduled_headway_seconds"`
+}
+
+// calculateHeadways estimates the time gaps between consecutive vehicles in each direction of a route.
+//
+// It assumes every vehicle covers the gap to the one ahead of it at the same average speed (in km/h),
+// so it ignores dwell times at stops, traffic lights and the speed varying along the route.
+// The gap is measured along the trips' shapes, so vehicles without a shape are left out.
+func calculateHeadways(routeID RouteID, vehicles Vehicles, averageSpeed float64) []DirectionHeadway {
+	metersPerSecond := averageSpeed / 3.6
+
+	headways := []DirectionHeadway{}
+	for directionID, directionVehicles := range vehiclesByDirection(vehicles) {
+		headway := DirectionHeadway{DirectionID: directionID, Vehicles: len(directionVehicles)}
+		for _, v := range directionVehicles {
+			trip, _ := getTrip(routeID, v.tripID)
+			if scheduled, ok := getScheduledHeadway(trip, time.Now()); ok {
+				headway.ScheduledSeconds = &scheduled
+				break
+			}
+		}
+
+		gaps := []float64{}
+		for i := 1; i < len(directionVehicles); i++ {
+			gap := (directionVehicles[i-1].distanceAlong - directionVehicles[i].distanceAlong) / metersPerSecond
+			gaps = append(gaps, gap)
+		}
+
+		if len(gaps) > 0 {
+			total := 0.0
+			for _, gap := range gaps {
+				total += gap
+			}
+			average := total / float64(len(gaps))
+			minGap, maxGap := slices.Min(gaps), slices.Max(gaps)
+			headway.AverageSeconds, headway.MinSeconds, headway.MaxSeconds = &average, &minGap, &maxGap
+		}
+		headways = append(headways, headway)
+	}
+
+	slices.SortFunc(headways, func(a, b DirectionHeadway) int {
+		switch {
+		case a.DirectionID < b.DirectionID:
+			return -1
+		case a.DirectionID > b.DirectionID:
+			return 1
+		}
+		return 0
+	})
+	return headways
+}
+
+func headwayHandler(w http.ResponseWriter, r *http.Request) {
+	routeID := RouteID(r.PathValue("id"))
+	vehicles := allVehicles.Load().(*Snapshot).Routes[routeID]
+	averageSpeed := getTunables().AverageSpeed
+
+	response := struct {
+		RouteID      RouteID            `json:"route_id"`
+		AverageSpeed Speed              `json:"assumed_speed"`
+		Directions   []DirectionHeadway `json:"directions"`
+		Units        Units              `json:"units"`
+	}{
+		Units:        apiUnits(),
+		RouteID:      routeID,
+		AverageSpeed: Speed(averageSpeed),
+		Directions:   calculateHeadways(routeID, vehicles, averageSpeed),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// the feed timestamp only moves once per poll at best, so anything at or below -poll-interval
+// would flag the feed as stale between every two polls, ZET also pauses the feed for a few polls now and then
+var staleAfter = flag.Duration("stale-after", 30*time.Second, "how old the feed can get before /healthz fails and a warning is logged, independent of -poll-interval and meant to be a few times larger")
+
+var expireAfter = flag.Duration("expire-after", 0, "how old the feed can get before its vehicles stop being served, 0 to keep serving the last ones, should be longer than -stale-after")
+
+// whether the stale warning was already logged, so it's logged once per outage
+var feedStale atomic.Bool
+
+// getFeedAge is how long ago the latest feed was generated
+func getFeedAge() time.Duration {
+	timestamp := atomic.LoadUint64(&lastUpdateTimestamp)
+	if timestamp == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(int64(timestamp), 0))
+}
+
+// isFeedStale tells whether the feed hasn't moved for longer than -stale-after, recordings are never stale
+func isFeedStale() bool {
+	return *replayDir == "" && getFeedAge() > *staleAfter
+}
+
+// checkFeedAge logs when the feed goes stale and when it recovers, pushing the stale flag to the streaming clients
+// and clearing the vehicles once the feed expires
+func checkFeedAge() {
+	snapshot := allVehicles.Load().(*Snapshot)
+	if *expireAfter > 0 && *replayDir == "" && getFeedAge() > *expireAfter && snapshot.vehicleCount() > 0 {
+		log.Printf("Feed is %v old, older than the -expire-after of %v, clearing the vehicles\n", getFeedAge().Round(time.Second), *expireAfter)
+		snapshot = newSnapshot(map[RouteID]Vehicles{}, snapshot.Timestamp)
+		snapshot.Sequence = snapshotSequence.Add(1)
+		allVehicles.Store(snapshot)
+		vehicleBroadcaster.publish(snapshot)
+	}
+
+	stale := isFeedStale()
+	if stale == feedStale.Swap(stale) {
+		return
+	}
+	if stale {
+		log.Printf("Feed is %v old, older than the -stale-after of %v\n", getFeedAge().Round(time.Second), *staleAfter)
+		// otherwise the clients wouldn't hear about it before the next feed
+		vehicleBroadcaster.publish(snapshot)
+	} else {
+		log.Println("Feed is fresh again")
+	}
+}
+
+// healthHandler fails once the feed is older than -stale-after, unlike /readyz which only waits for the first feed
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	stale := isFeedStale()
+	response := struct {
+		Healthy    bool    `json:"healthy"`
+		FeedAge    float64 `json:"feed_age_seconds"`
+		StaleAfter float64 `json:"stale_after_seconds"`
+	}{
+		Healthy:    !stale,
+		FeedAge:    getFeedAge().Seconds(),
+		StaleAfter: staleAfter.Seconds(),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if stale {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	newJSONEncoder(w, r).Encode(response)
+}
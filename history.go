@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var historyRetention = flag.Duration("history-retention", 30*time.Minute, "how far back /history goes, 0 to not keep any")
+var historyMaxPoints = flag.Int("history-max-points", 500000, "most vehicle positions kept for /history, the oldest are dropped first")
+
+type HistoryPoint struct {
+	Timestamp uint64  `json:"timestamp"` // feed timestamp, unix epoch
+	RouteID   RouteID `json:"route_id"`
+	Lat       float32 `json:"lat"`
+	Lon       float32 `json:"lon"`
+	Direction int     `json:"direction"`
+}
+
+// historyBucket is one snapshot's positions
+type historyBucket struct {
+	timestamp uint64
+	positions map[string]HistoryPoint
+}
+
+// history holds the buckets of the recent snapshots, oldest first
+var history = struct {
+	sync.RWMutex
+	buckets []historyBucket
+	points  int
+}{}
+
+// recordHistory adds the snapshot's positions and drops the ones past the retention or over the cap
+func recordHistory(snapshot *Snapshot) {
+	if *historyRetention <= 0 || snapshot.Timestamp == 0 {
+		return
+	}
+
+	bucket := historyBucket{timestamp: snapshot.Timestamp, positions: make(map[string]HistoryPoint, snapshot.TotalVehicles)}
+	for routeID, vehicles := range snapshot.Routes {
+		for _, v := range vehicles {
+			bucket.positions[v.ID] = HistoryPoint{
+				Timestamp: snapshot.Timestamp,
+				RouteID:   routeID,
+				Lat:       v.Latitude,
+				Lon:       v.Longitude,
+				Direction: v.Direction,
+			}
+		}
+	}
+
+	history.Lock()
+	defer history.Unlock()
+
+	history.buckets = append(history.buckets, bucket)
+	history.points += len(bucket.positions)
+
+	oldest := snapshot.Timestamp - uint64(historyRetention.Seconds())
+	drop := 0
+	for drop < len(history.buckets)-1 && (history.buckets[drop].timestamp < oldest || history.points > *historyMaxPoints) {
+		history.points -= len(history.buckets[drop].positions)
+		drop++
+	}
+	history.buckets = history.buckets[drop:]
+}
+
+// getVehicleHistory returns the vehicle's positions between from and to, inclusive
+func getVehicleHistory(vehicleID string, from, to uint64) []HistoryPoint {
+	history.RLock()
+	defer history.RUnlock()
+
+	points := []HistoryPoint{}
+	for _, bucket := range history.buckets {
+		if bucket.timestamp < from || bucket.timestamp > to {
+			continue
+		}
+		if point, exists := bucket.positions[vehicleID]; exists {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// historyHandler returns a vehicle's recent trail, from and to are unix epochs defaulting to the whole retention,
+// with ?format=polyline the positions are only returned as an encoded polyline
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	polyline, err := wantsPolyline(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	vehicleID := query.Get("vehicle")
+	if vehicleID == "" {
+		writeJSONError(w, http.StatusBadRequest, "vehicle is required")
+		return
+	}
+
+	const maxEpoch = 1 << 40
+	from, err := queryInt(query, "from", 0, 0, maxEpoch)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := queryInt(query, "to", maxEpoch, 0, maxEpoch)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if from > to {
+		writeJSONError(w, http.StatusBadRequest, "from has to be before to")
+		return
+	}
+
+	points := getVehicleHistory(vehicleID, uint64(from), uint64(to))
+	response := struct {
+		VehicleID string          `json:"vehicle_id"`
+		Points    *[]HistoryPoint `json:"points,omitempty"`
+		Polyline  *string         `json:"polyline,omitempty"`
+	}{
+		VehicleID: vehicleID,
+	}
+	if polyline {
+		trail := make([]Point, len(points))
+		for i, p := range points {
+			trail[i] = Point{Lat: float64(p.Lat), Lon: float64(p.Lon)}
+		}
+		encoded := encodePolyline(trail)
+		response.Polyline = &encoded
+	} else {
+		response.Points = &points
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// resetHistory empties the history for the duration of the test
+func resetHistory(t *testing.T) {
+	clearHistory := func() {
+		history.Lock()
+		history.buckets, history.points = nil, 0
+		history.Unlock()
+	}
+	clearHistory()
+	t.Cleanup(clearHistory)
+}
+
+func historySnapshot(timestamp uint64, ids ...string) *Snapshot {
+	vehicles := Vehicles{}
+	for i, id := range ids {
+		vehicles = append(vehicles, Vehicle{ID: id, Latitude: 45.8 + float32(i)*0.01, Longitude: 15.97 + float32(timestamp%100)*0.001})
+	}
+	return newSnapshot(map[RouteID]Vehicles{"6": vehicles}, timestamp)
+}
+
+func TestRecordHistoryRetention(t *testing.T) {
+	resetHistory(t)
+	retention := uint64(historyRetention.Seconds())
+
+	recordHistory(historySnapshot(fixtureTimestamp, "101", "102"))
+	recordHistory(historySnapshot(fixtureTimestamp+retention, "101"))
+	if points := getVehicleHistory("101", 0, 1<<40); len(points) != 2 {
+		t.Fatalf("%d points within the retention, want 2", len(points))
+	}
+
+	recordHistory(historySnapshot(fixtureTimestamp+retention+1, "101"))
+	points := getVehicleHistory("101", 0, 1<<40)
+	if len(points) != 2 || points[0].Timestamp != fixtureTimestamp+retention {
+		t.Errorf("points %+v, want the two within the retention", points)
+	}
+	if points := getVehicleHistory("102", 0, 1<<40); len(points) != 0 {
+		t.Errorf("vehicle 102's only point is past the retention but still there: %+v", points)
+	}
+
+	if points := getVehicleHistory("101", fixtureTimestamp+retention+1, fixtureTimestamp+retention+1); len(points) != 1 {
+		t.Errorf("%d points in a one second window, want 1", len(points))
+	}
+
+	// a snapshot without a feed timestamp can't be placed in time
+	recordHistory(historySnapshot(0, "101"))
+	if points := getVehicleHistory("101", 0, 1<<40); len(points) != 2 {
+		t.Errorf("a snapshot without a timestamp was recorded")
+	}
+}
+
+func TestRecordHistoryMaxPoints(t *testing.T) {
+	resetHistory(t)
+	previous := *historyMaxPoints
+	*historyMaxPoints = 5
+	t.Cleanup(func() { *historyMaxPoints = previous })
+
+	for i := range uint64(4) {
+		recordHistory(historySnapshot(fixtureTimestamp+i, "101", "102"))
+	}
+	history.RLock()
+	points, buckets := history.points, len(history.buckets)
+	history.RUnlock()
+	if points > 5 || buckets != 2 {
+		t.Errorf("%d points in %d buckets, want at most 5 points in the latest 2 buckets", points, buckets)
+	}
+
+	// the latest snapshot is kept even if it alone is over the cap
+	*historyMaxPoints = 1
+	recordHistory(historySnapshot(fixtureTimestamp+10, "101", "102"))
+	if points := getVehicleHistory("102", 0, 1<<40); len(points) != 1 || points[0].Timestamp != fixtureTimestamp+10 {
+		t.Errorf("points %+v, want only the latest", points)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	resetHistory(t)
+	start := uint64(time.Now().Unix())
+	for i := range uint64(3) {
+		recordHistory(historySnapshot(start+i*10, "101"))
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		historyHandler(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder
+	}
+
+	recorder := get("/history?vehicle=101&from=" + strconv.FormatUint(start+10, 10))
+	var response struct {
+		VehicleID string         `json:"vehicle_id"`
+		Points    []HistoryPoint `json:"points"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%v\n%s", err, recorder.Body)
+	}
+	if response.VehicleID != "101" || len(response.Points) != 2 || response.Points[0].Timestamp != start+10 {
+		t.Errorf("got %+v, want 101's last two points", response)
+	}
+
+	recorder = get("/history?vehicle=101&format=polyline")
+	var polylineResponse struct {
+		Points   []HistoryPoint `json:"points"`
+		Polyline string         `json:"polyline"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &polylineResponse); err != nil {
+		t.Fatalf("%v\n%s", err, recorder.Body)
+	}
+	if polylineResponse.Points != nil || len(decodePolyline(t, polylineResponse.Polyline)) != 3 {
+		t.Errorf("got %s, want only a polyline of 3 points", recorder.Body)
+	}
+
+	for _, target := range []string{"/history", "/history?vehicle=101&from=20&to=10"} {
+		if recorder := get(target); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", target, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -2,40 +2,112 @@ package main
 
 import (
 	"archive/zip"
-	"bytes"
-	"encoding/csv"
+	"cmp"
 	"encoding/json"
 	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
 	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	_ "time/tzdata" // the container might not have a timezone database
 
 	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
-const gtfsURL = "https://zet.hr/gtfs-rt-protobuf"
-const tripsDataURL = "https://www.zet.hr/gtfs-scheduled/latest"
+// upstream URLs and the client used to reach them, ZET's unless configured otherwise
+var gtfsURL, tripsDataURL string
+var httpClient = &http.Client{}
+
+func init() {
+	flag.StringVar(&gtfsURL, "gtfs-rt-url", getenvOr("GTFS_RT_URL", "https://zet.hr/gtfs-rt-protobuf"), "GTFS Realtime feed URL, $GTFS_RT_URL if not set")
+	flag.StringVar(&tripsDataURL, "schedule-url", getenvOr("SCHEDULE_URL", "https://www.zet.hr/gtfs-scheduled/latest"), "static GTFS zip URL, $SCHEDULE_URL if not set")
+}
+
+func getenvOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+const allowedOrigin = "*"
+
+var noSchedule = flag.Bool("no-schedule", false, "never fetch the schedule and serve positions only, without headsigns, stops or shapes")
+var enableDebug = flag.Bool("debug", false, "serve troubleshooting data under /debug, like the latest feed decoded as JSON")
+var enablePprof = flag.Bool("pprof", false, "serve profiling data under /debug/pprof and expvar metrics under /debug/vars")
 
 type Vehicles []Vehicle
 type Vehicle struct {
 	ID        string  `json:"id"`
 	Latitude  float32 `json:"lat"`
 	Longitude float32 `json:"lon"`
-	Headsign  string  `json:"headsign"`
-	Direction int     `json:"direction"`
+	// position as reported by the feed, lat/lon differ only when snapping is enabled
+	RawLatitude  float32 `json:"raw_lat"`
+	RawLongitude float32 `json:"raw_lon"`
+	Headsign     string  `json:"headsign"`
+	Direction    int     `json:"direction"`
+	// the trip's direction_id as riders know it, "outbound" or "inbound" unless configured for the route
+	DirectionLabel string `json:"direction_label,omitempty"`
+	// how far along its trip's shape the vehicle is, from 0 at the origin to 1 at the terminus
+	Progress *float64 `json:"progress,omitempty"`
+	NextStop string   `json:"next_stop,omitempty"`
+	// positive when the vehicle is running late
+	DelaySeconds *int32 `json:"delay_seconds,omitempty"`
+	// the next few stops of the trip with their predicted arrivals, from the trip's update
+	NextStops []StopPrediction `json:"next_stops,omitempty"`
+	// distance travelled this service day, see updateOdometers
+	OdometerMeters       Distance      `json:"odometer"`
+	WheelchairAccessible Accessibility `json:"wheelchair_accessible"`
+	// when this instance of the trip started, ISO-8601 in Zagreb's time
+	TripStart string `json:"trip_start,omitempty"`
+	// rough arrival at the trip's last stop, assuming the average speed for the rest of the shape
+	// and ignoring stops and traffic, ISO-8601 in Zagreb's time
+	ETATerminus string `json:"eta_terminus_estimate,omitempty"`
+	// hex without the leading #, empty when the schedule doesn't say
+	RouteColor     string `json:"route_color,omitempty"`
+	RouteTextColor string `json:"route_text_color,omitempty"`
+	// further from its trip's shape than the off-route threshold, a detour or the wrong trip assigned
+	OffRoute bool `json:"off_route"`
+	// as reported by the feed or else computed from the previous report, missing for vehicles seen only once
+	Speed *Speed `json:"speed,omitempty"`
+	// "incoming_at", "stopped_at" or "in_transit_to" the stop the feed reports, empty when the feed doesn't say
+	Status string `json:"status,omitempty"`
+	// the stop's name while the vehicle is stopped at it
+	StoppedAt string `json:"stopped_at,omitempty"`
+
+	directionID string
+	tripID      TripID
+	// the vehicle's own timestamp, unix epoch
+	reportedAt uint64
+	// meters travelled from the origin along the trip's shape, set only when Progress is
+	distanceAlong float64
+	// meters from the reported position to the trip's shape
+	distanceFromShape float64
 }
 
 type Trip struct {
-	Headsign  string
-	Direction string
+	Headsign             string
+	ShortName            string
+	ServiceID            string
+	Direction            string
+	ShapeID              ShapeID
+	WheelchairAccessible Accessibility
+
+	// set only for frequency-based trips, whose trip_id stands for a whole series of departures
+	Frequencies []Frequency
 }
 
 type RouteID string
@@ -44,12 +116,10 @@ type Trips map[TripID]Trip
 
 type RoutesToTrips map[RouteID]Trips
 
-var routesToTrips = RoutesToTrips{}
-var cachedTripsFilename = ""
 var mu sync.RWMutex = sync.RWMutex{}
 
-func fetchGTFSRealTime(url string) (*gtfs.FeedMessage, error) {
-	resp, err := http.Get(url)
+func fetchGTFSRealTime(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to fetch GTFS Realtime feed: %v", err)
 	}
@@ -64,6 +134,56 @@ func fetchGTFSRealTime(url string) (*gtfs.FeedMessage, error) {
 		return nil, fmt.Errorf("Failed to read response body: %v", err)
 	}
 
+	return data, nil
+}
+
+// fetchFeed gets the next feed from the source and parses it, keeping count of how often that fails
+func fetchFeed(source feedSource) ([]byte, *gtfs.FeedMessage, error) {
+	data, err := source.fetch()
+	if errors.Is(err, errReplayFinished) {
+		return nil, nil, err
+	}
+
+	var feed *gtfs.FeedMessage
+	if err == nil {
+		feed, err = parseGTFSRealTime(data)
+		if err != nil {
+			recordMalformedFeed(data, err)
+		}
+	}
+
+	if err != nil {
+		feedFetchesFailed.Add(1)
+		feedConsecutiveFailures.Add(1)
+		return nil, nil, err
+	}
+	feedFetchesSucceeded.Add(1)
+	feedConsecutiveFailures.Store(0)
+	checkFeedVersion(feed.GetHeader().GetGtfsRealtimeVersion())
+	return data, feed, nil
+}
+
+// the major versions of the GTFS Realtime spec the bindings were generated for
+var supportedFeedVersions = []string{"1", "2"}
+
+// the feed's gtfs_realtime_version, as of the latest fetch
+var feedVersion atomic.Value // string
+
+// checkFeedVersion logs the feed's spec version whenever it changes, warning when the bindings might not understand it
+func checkFeedVersion(version string) {
+	if previous, _ := feedVersion.Swap(version).(string); previous == version {
+		return
+	}
+
+	major, _, _ := strings.Cut(version, ".")
+	if !slices.Contains(supportedFeedVersions, major) {
+		log.Printf("Feed is GTFS Realtime version %q, which might not be fully supported, data could be silently missing\n", version)
+		return
+	}
+	log.Printf("Feed is GTFS Realtime version %q\n", version)
+}
+
+func parseGTFSRealTime(data []byte) (*gtfs.FeedMessage, error) {
 	feed := &gtfs.FeedMessage{}
 	if err := proto.Unmarshal(data, feed); err != nil {
 		return nil, fmt.Errorf("Failed to parse protobuf: %v", err)
@@ -75,7 +195,7 @@ func fetchGTFSRealTime(url string) (*gtfs.FeedMessage, error) {
 func getTrip(route_id RouteID, trip_id TripID) (Trip, bool) {
 	mu.RLock()
 	defer mu.RUnlock()
-	route, exists := routesToTrips[route_id]
+	route, exists := schedule.Trips[route_id]
 	if exists {
 		if trip, exists := route[trip_id]; exists {
 			return trip, true
@@ -84,7 +204,11 @@ func getTrip(route_id RouteID, trip_id TripID) (Trip, bool) {
 	return Trip{}, false
 }
 
-var allVehicles atomic.Value
+var allVehicles atomic.Value // *Snapshot
+var lastFeed atomic.Value    // []byte, the raw protobuf of the latest feed
+// a feed going from at least this many vehicles to none is treated as a glitch
+const minVehiclesBeforeEmptyFeed = 10
+
 var lastUpdateTimestamp uint64 = 0
 
 type Point struct {
@@ -92,9 +216,15 @@ type Point struct {
 	Lon float64 `json:"lon"`
 }
 
+// calculateBearing returns the direction from p1 to p2 in degrees counter-clockwise from east, in [0, 360),
+// it's what the map rotates the markers by, not a compass bearing (see geojson.go for that),
+// identical points and a missing coordinate deterministically give 0 instead of whatever rounding or NaN would
 func calculateBearing(p1, p2 Point) float64 {
 	dx := p2.Lon - p1.Lon
 	dy := p2.Lat - p1.Lat
+	if (dx == 0 && dy == 0) || math.IsNaN(dx) || math.IsNaN(dy) {
+		return 0
+	}
 
 	angle := math.Atan2(dy, dx) * 180 / math.Pi
 	if angle < 0 {
@@ -103,6 +233,8 @@ func calculateBearing(p1, p2 Point) float64 {
 	return angle
 }
 
+// calculateDistance returns the planar distance in degrees, only good for comparing against the move threshold,
+// use geoDistance for meters
 func calculateDistance(p1, p2 Point) float64 {
 	dx := p2.Lon - p1.Lon
 	dy := p2.Lat - p1.Lat
@@ -112,78 +244,270 @@ func calculateDistance(p1, p2 Point) float64 {
 
 func getVehiclesData(feed *gtfs.FeedMessage) ([]*gtfs.VehiclePosition, error) {
 	vehicles := []*gtfs.VehiclePosition{}
+	// the same vehicle can show up more than once (a stale and a fresh entity), only the newest one is kept
+	seen := map[string]int{}
 	for _, entity := range feed.Entity {
-		if entity.Vehicle != nil {
-			vehicles = append(vehicles, entity.Vehicle)
+		if entity.Vehicle == nil {
+			continue
 		}
+
+		id := entity.Vehicle.GetVehicle().GetId()
+		if i, exists := seen[id]; exists && id != "" {
+			if entity.Vehicle.GetTimestamp() >= vehicles[i].GetTimestamp() {
+				vehicles[i] = entity.Vehicle
+			}
+			continue
+		}
+		seen[id] = len(vehicles)
+		vehicles = append(vehicles, entity.Vehicle)
 	}
 	return vehicles, nil
 }
 
-func getRoutes(vehicles []*gtfs.VehiclePosition) map[RouteID]Vehicles {
-	routes := map[RouteID]Vehicles{}
+// getHeadsign falls back to the trip's short name and then the route's long name for trips without a headsign
+func getHeadsign(routeID RouteID, trip Trip) string {
+	if trip.Headsign != "" {
+		return trip.Headsign
+	}
+	if trip.ShortName != "" {
+		return trip.ShortName
+	}
+	route, _ := getRoute(routeID)
+	return route.LongName
+}
+
+// dropStaleVehicles leaves out the vehicles the feed keeps echoing long after their last report,
+// their age is measured against the feed's timestamp so replays behave the same
+func dropStaleVehicles(vehicles []*gtfs.VehiclePosition, feedTimestamp uint64) []*gtfs.VehiclePosition {
+	maxAge := time.Duration(getTunables().MaxVehicleAge)
+	if maxAge == 0 || feedTimestamp == 0 {
+		return vehicles
+	}
+
+	fresh := make([]*gtfs.VehiclePosition, 0, len(vehicles))
 	for _, v := range vehicles {
-		routeID := RouteID(v.GetTrip().GetRouteId())
-		tripID := TripID(v.GetTrip().GetTripId())
-		if _, exists := routes[routeID]; !exists {
-			routes[routeID] = Vehicles{}
+		// without a timestamp there's no telling how old it is
+		if v.Timestamp == nil || time.Duration(int64(feedTimestamp)-int64(v.GetTimestamp()))*time.Second <= maxAge {
+			fresh = append(fresh, v)
 		}
-		trip, exists := getTrip(routeID, tripID)
-		if !exists {
-			if isTripsDataStale() { // cache needs updating
-				log.Printf("Refetching trips data because routeID %v or tripID %v don't exist", routeID, tripID)
-				tripsData, err := getTripsData()
-				if err != nil {
-					mu.Lock()
-					defer mu.Unlock()
-					clear(routesToTrips)
-					for _, row := range tripsData {
-						routeID := RouteID(row[0])
-						if _, exists := routesToTrips[routeID]; !exists {
-							routesToTrips[routeID] = Trips{}
-						}
-
-						tripID := TripID(row[2])
-						routesToTrips[routeID][tripID] = Trip{Headsign: row[3], Direction: row[5]}
-					}
-				}
-				trip, exists = getTrip(routeID, tripID) // it should exist now
+	}
+	if dropped := len(vehicles) - len(fresh); dropped > 0 {
+		log.Printf("Dropped %d vehicles not heard from in over %v\n", dropped, maxAge)
+	}
+	return fresh
+}
+
+func buildVehicle(v *gtfs.VehiclePosition, trip Trip, predictions map[TripID]tripPrediction) Vehicle {
+	tripID := TripID(v.GetTrip().GetTripId())
+	vehicle := Vehicle{
+		ID:           v.GetVehicle().GetId(),
+		Latitude:     v.GetPosition().GetLatitude(),
+		Longitude:    v.GetPosition().GetLongitude(),
+		RawLatitude:  v.GetPosition().GetLatitude(),
+		RawLongitude: v.GetPosition().GetLongitude(),
+		Headsign:     getHeadsign(RouteID(v.GetTrip().GetRouteId()), trip),
+		directionID:  trip.Direction,
+		tripID:       tripID,
+		reportedAt:   v.GetTimestamp(),
+
+		WheelchairAccessible: trip.WheelchairAccessible,
+	}
+	if v.GetPosition().Speed != nil {
+		speed := Speed(v.GetPosition().GetSpeed() * 3.6) // the feed has it in m/s
+		vehicle.Speed = &speed
+	}
+	vehicle.DirectionLabel = getDirectionLabel(RouteID(v.GetTrip().GetRouteId()), v.GetTrip(), trip)
+	if route, exists := getRoute(RouteID(v.GetTrip().GetRouteId())); exists {
+		vehicle.RouteColor, vehicle.RouteTextColor = route.Color, route.TextColor
+	}
+	if start, ok := getTripStart(v.GetTrip()); ok {
+		vehicle.TripStart = formatZagrebTime(start.Unix())
+	}
+	if prediction, exists := predictions[tripID]; exists {
+		vehicle.DelaySeconds = prediction.delay
+		vehicle.NextStops = prediction.nextStops
+	}
+	if nextStop, exists := getNextStop(tripID, v); exists {
+		vehicle.NextStop = nextStop.Name
+	}
+	if v.CurrentStatus != nil {
+		vehicle.Status = strings.ToLower(v.GetCurrentStatus().String())
+	}
+	if stop, exists := getStoppedAt(tripID, v); exists {
+		vehicle.StoppedAt = stop.Name
+	}
+	if shape, exists := getShape(trip.ShapeID); exists {
+		position := Point{Lat: float64(vehicle.Latitude), Lon: float64(vehicle.Longitude)}
+		snapped, along := shape.snap(position)
+		vehicle.distanceFromShape = geoDistance(position, snapped)
+		vehicle.OffRoute = shape.isOffRoute(vehicle.distanceFromShape, along, getTunables().OffRouteThreshold)
+		if getTunables().SnapToShape {
+			vehicle.Latitude = float32(snapped.Lat)
+			vehicle.Longitude = float32(snapped.Lon)
+		}
+		if progress, ok := shape.progress(along, trip.Direction); ok {
+			vehicle.Progress = &progress
+			vehicle.distanceAlong = progress * shape.length()
+			if eta, ok := estimateTerminusArrival(v, shape.length()-vehicle.distanceAlong); ok {
+				vehicle.ETATerminus = formatZagrebTime(eta.Unix())
+			}
+		}
+	}
+	return vehicle
+}
+
+// estimateTerminusArrival extrapolates when the vehicle covers the remaining meters from its last report
+func estimateTerminusArrival(v *gtfs.VehiclePosition, remaining float64) (time.Time, bool) {
+	metersPerSecond := getTunables().AverageSpeed / 3.6
+	if metersPerSecond <= 0 || remaining < 0 {
+		return time.Time{}, false
+	}
+
+	reported := time.Now()
+	if v.Timestamp != nil {
+		reported = time.Unix(int64(v.GetTimestamp()), 0)
+	}
+	return reported.Add(time.Duration(remaining / metersPerSecond * float64(time.Second))), true
+}
+
+// below this many vehicles per worker the goroutines cost more than they save
+const minVehiclesPerWorker = 64
+
+// buildRoutes splits the vehicles between workers and merges their results in feed order,
+// it also returns the vehicles whose trips aren't in the schedule
+func buildRoutes(vehicles []*gtfs.VehiclePosition, predictions map[TripID]tripPrediction) (map[RouteID]Vehicles, []*gtfs.VehiclePosition) {
+	type partial struct {
+		routes  map[RouteID]Vehicles
+		missing []*gtfs.VehiclePosition
+	}
+
+	// the fleet barely changes between ticks, so the previous snapshot's sizes save rehashing and regrowing
+	previous := map[RouteID]Vehicles{}
+	if snapshot, ok := allVehicles.Load().(*Snapshot); ok {
+		previous = snapshot.Routes
+	}
+
+	workers := max(1, min(runtime.GOMAXPROCS(0), len(vehicles)/minVehiclesPerWorker))
+	chunkSize := (len(vehicles) + workers - 1) / workers
+	partials := make([]partial, workers)
+
+	var wg sync.WaitGroup
+	for i := range partials {
+		chunk := vehicles[min(i*chunkSize, len(vehicles)):min((i+1)*chunkSize, len(vehicles))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := partial{routes: make(map[RouteID]Vehicles, len(previous))}
+			for _, v := range chunk {
+				routeID := RouteID(v.GetTrip().GetRouteId())
+				trip, exists := getTrip(routeID, TripID(v.GetTrip().GetTripId()))
 				if !exists {
-					log.Printf("Route (route ID: %v, trip ID: %v) doesn't exist even after refetching data, this should not happen\n", routeID, tripID)
+					result.missing = append(result.missing, v)
 				}
-			} else {
-				log.Printf("%v or %v don't exist in the cache, but the cache is up to date (%s)\n", routeID, tripID, cachedTripsFilename)
+				result.routes[routeID] = append(result.routes[routeID], buildVehicle(v, trip, predictions))
+			}
+			partials[i] = result
+		}()
+	}
+	wg.Wait()
+
+	routes := make(map[RouteID]Vehicles, len(previous))
+	missing := []*gtfs.VehiclePosition{}
+	for _, p := range partials {
+		for routeID, routeVehicles := range p.routes {
+			if _, exists := routes[routeID]; !exists {
+				routes[routeID] = make(Vehicles, 0, max(len(previous[routeID]), len(routeVehicles)))
+			}
+			routes[routeID] = append(routes[routeID], routeVehicles...)
+		}
+		missing = append(missing, p.missing...)
+	}
+
+	// feed order changes between messages, a stable order keeps the output reproducible
+	for _, routeVehicles := range routes {
+		slices.SortFunc(routeVehicles, func(a, b Vehicle) int { return strings.Compare(a.ID, b.ID) })
+	}
+	return routes, missing
+}
+
+// capRouteVehicles keeps the most recently reported vehicles of routes with more than the limit,
+// no real route runs that many so the rest are bogus entries from a feed glitch
+func capRouteVehicles(routes map[RouteID]Vehicles, limit int) {
+	if limit <= 0 {
+		return
+	}
+	for routeID, vehicles := range routes {
+		if len(vehicles) <= limit {
+			continue
+		}
+		log.Printf("Route %s has %d vehicles, keeping only the %d most recently reported\n", routeID, len(vehicles), limit)
+		slices.SortStableFunc(vehicles, func(a, b Vehicle) int { return cmp.Compare(b.reportedAt, a.reportedAt) })
+		vehicles = vehicles[:limit]
+		slices.SortFunc(vehicles, func(a, b Vehicle) int { return strings.Compare(a.ID, b.ID) })
+		routes[routeID] = vehicles
+	}
+}
+
+// logUnlabeled reports the vehicles left without a headsign even after the fallbacks, so gaps in the schedule are visible
+func logUnlabeled(routes map[RouteID]Vehicles) {
+	if !isScheduleAvailable() {
+		return
+	}
+
+	unlabeled := 0
+	for _, vehicles := range routes {
+		for _, v := range vehicles {
+			if v.Headsign == "" {
+				unlabeled++
 			}
 		}
-		routes[routeID] = append(routes[routeID], Vehicle{
-			ID:        v.GetVehicle().GetId(),
-			Latitude:  v.GetPosition().GetLatitude(),
-			Longitude: v.GetPosition().GetLongitude(),
-			Headsign:  trip.Headsign,
-		})
+	}
+	if unlabeled > 0 {
+		log.Printf("%d vehicles have no headsign, trip short name or route long name\n", unlabeled)
+	}
+}
+
+func getRoutes(vehicles []*gtfs.VehiclePosition, predictions map[TripID]tripPrediction) map[RouteID]Vehicles {
+	vehicles, dropped := dropOutsideServiceArea(vehicles)
+	if dropped > 0 {
+		log.Printf("Dropped %d vehicles reporting positions outside of the service area\n", dropped)
+	}
+
+	vehicles = dropUnservedRoutes(vehicles)
+
+	routes, missing := buildRoutes(vehicles, predictions)
+	capRouteVehicles(routes, getTunables().MaxRouteVehicles)
+	if len(missing) == 0 || *noSchedule {
+		return routes
+	}
+
+	// the check runs in the background, the missing vehicles show up on the first tick after a reload
+	scheduleCheck.request()
+	if !isScheduleAvailable() || !scheduleCheck.upToDate.Load() {
+		return routes
+	}
+	for _, v := range missing {
+		log.Printf("%v or %v don't exist in the cache, but the cache is up to date (%s)\n", v.GetTrip().GetRouteId(), v.GetTrip().GetTripId(), getScheduleFilename())
+		recordUnresolved(RouteID(v.GetTrip().GetRouteId()), TripID(v.GetTrip().GetTripId()))
 	}
 	return routes
 }
 
-func calculateVehicleBearings(oldRoutes, newRoutes map[RouteID]Vehicles) map[RouteID]Vehicles {
+func calculateVehicleBearings(old *Snapshot, newRoutes map[RouteID]Vehicles) map[RouteID]Vehicles {
+	settings := getTunables()
 	for routeID, vehicles := range newRoutes {
 		// new route was added, no reason to calculate anything, all the directions are irrelevant
-		if _, exists := oldRoutes[routeID]; !exists {
+		if _, exists := old.Routes[routeID]; !exists {
 			continue
 		}
 
-		oldRouteVehicles := oldRoutes[routeID]
 		for i, newVehicle := range vehicles {
-			sameID := func(v Vehicle) bool { return newVehicle.ID == v.ID }
-
-			oldVehicleIdx := slices.IndexFunc(oldRoutes[routeID], sameID)
+			oldRouteID, oldVehicle, exists := old.getVehicle(newVehicle.ID)
 			// a new vehicle, direction is irrelevant
-			if oldVehicleIdx == -1 {
+			if !exists || oldRouteID != routeID {
 				continue
 			}
 
-			oldVehicle := oldRouteVehicles[oldVehicleIdx]
-
 			oldPosition := Point{Lat: float64(oldVehicle.Latitude), Lon: float64(oldVehicle.Longitude)}
 			newPosition := Point{Lat: float64(newVehicle.Latitude), Lon: float64(newVehicle.Longitude)}
 
@@ -193,15 +517,13 @@ func calculateVehicleBearings(oldRoutes, newRoutes map[RouteID]Vehicles) map[Rou
 			newRoutes[routeID][i].Direction = oldAzimuth
 
 			// if the position hasn't changed a lot, it's probably a sitting duck
-			moveThreshold := 1e-5
 			distance := calculateDistance(oldPosition, newPosition)
-			if distance < moveThreshold {
+			if distance < settings.MoveThreshold {
 				continue
 			}
 
 			// if the bearing does not differ much, ignore the update
-			threshold := float64(3) // degrees
-			if math.Abs(float64(newAzimuth-oldVehicle.Direction)) > threshold {
+			if math.Abs(float64(newAzimuth-oldVehicle.Direction)) > settings.BearingThreshold {
 				newRoutes[routeID][i].Direction = newAzimuth
 			}
 
@@ -211,61 +533,131 @@ func calculateVehicleBearings(oldRoutes, newRoutes map[RouteID]Vehicles) map[Rou
 	return newRoutes
 }
 
+// static assets only change on deploys, ServeFile adds Last-Modified for revalidating after that
+const staticCacheControl = "public, max-age=86400"
+
 func faviconHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "data/favicon-32x32.png")
+	w.Header().Set("Cache-Control", staticCacheControl)
+	favicon.serve(w, r)
 }
 
 func vehicleHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := allVehicles.Load().(*Snapshot)
+
+	filters, err := parseVehicleFilters(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	routes := applyFilters(snapshot.Routes, filters)
+
+	w.Header().Set("Vary", "Accept")
+	// there's nothing new before the next poll
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(time.Duration(getTunables().PollInterval).Seconds())))
+	if snapshot.Timestamp != 0 {
+		w.Header().Set("Last-Modified", time.Unix(int64(snapshot.Timestamp), 0).UTC().Format(http.TimeFormat))
+	}
+
+	// JSON unless the client explicitly prefers GeoJSON
+	if negotiateContentType(r.Header.Get("Accept"), contentTypeJSON, contentTypeGeoJSON) == contentTypeGeoJSON {
+		if notModified(w, r, snapshot.etag("geojson")) {
+			return
+		}
+		// the features are built while they're streamed, so there's no separate build phase to report
+		w.Header().Set("Content-Type", contentTypeGeoJSON)
+		writeFeatureCollection(w, r, routes)
+		return
+	}
+
+	if notModified(w, r, snapshot.etag("json")) {
+		return
+	}
+
+	// bandwidth-sensitive clients can pick just the vehicle fields they draw
+	var vehicles any = routes
+	fields, unknown := parseFields(r.URL.Query().Get("fields"))
+	warnUnknownFields(w, unknown)
+	if fields != nil {
+		vehicles = projectVehicles(routes, fields)
+	}
+
+	// the totals are for the whole fleet, regardless of filters
 	response := struct {
-		Vehicles map[RouteID]Vehicles `json:"vehicles"`
+		Vehicles          any                `json:"vehicles"`
+		Predicted         []PredictedVehicle `json:"predicted,omitempty"`
+		TotalVehicles     int                `json:"total_vehicles"`
+		TotalRoutes       int                `json:"total_routes"`
+		ScheduleAvailable bool               `json:"schedule_available"`
+		Stale             bool               `json:"stale"`
+		Sequence          uint64             `json:"sequence"`
+		Units             Units              `json:"units"`
 	}{
-		Vehicles: allVehicles.Load().(map[RouteID]Vehicles),
+		Units:             apiUnits(),
+		Vehicles:          vehicles,
+		Predicted:         snapshot.Predicted,
+		TotalVehicles:     snapshot.TotalVehicles,
+		TotalRoutes:       snapshot.TotalRoutes,
+		ScheduleAvailable: isScheduleAvailable(),
+		Stale:             isFeedStale(),
+		Sequence:          snapshot.Sequence,
 	}
+	markTiming(w, "build")
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
 }
 
-func mapHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "index.html")
-}
+// readyHandler reports ready as soon as positions are served, the schedule only adds headsigns on top of them
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	_, hasVehicles := allVehicles.Load().(*Snapshot)
+	response := struct {
+		Ready             bool `json:"ready"`
+		ScheduleAvailable bool `json:"schedule_available"`
+	}{
+		Ready:             hasVehicles,
+		ScheduleAvailable: isScheduleAvailable(),
+	}
 
-func sseHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("SSE client connected")
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if !hasVehicles {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	newJSONEncoder(w, r).Encode(response)
+}
 
-	// Setup headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*") // optional
+// gtfsRealtimeHandler proxies the latest feed so clients speaking GTFS Realtime don't hit ZET directly
+func gtfsRealtimeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(lastFeed.Load().([]byte))
+}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+// debugFeedHandler dumps the whole latest feed, including the parts the map ignores
+func debugFeedHandler(w http.ResponseWriter, r *http.Request) {
+	feed, err := parseGTFSRealTime(lastFeed.Load().([]byte))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	clientLastUpdate := uint64(0)
-
-	// Keep the connection alive and send updates
-	for {
-		current := atomic.LoadUint64(&lastUpdateTimestamp)
-		if current > clientLastUpdate {
-			clientLastUpdate = current
-
-			vehicles := allVehicles.Load().(map[RouteID]Vehicles)
-			data, _ := json.Marshal(vehicles)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
-		}
+	options := protojson.MarshalOptions{}
+	if r.URL.Query().Get("pretty") == "1" {
+		options.Multiline = true
+	}
+	data, err := options.Marshal(feed)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 
-		time.Sleep(1 * time.Second)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(data)
+}
 
-		// Optional: detect client disconnect
-		if r.Context().Err() != nil {
-			log.Println("SSE client disconnected")
-			return
-		}
+func mapHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", staticCacheControl)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, getFrontendConfig()); err != nil {
+		log.Println("Could not render the map page: ", err)
 	}
 }
 
@@ -278,90 +670,212 @@ func readZipFile(zf *zip.File) ([]byte, error) {
 	return io.ReadAll(f)
 }
 
-func isTripsDataStale() bool {
-	resp, err := http.Head(tripsDataURL)
+// isTripsDataStale asks the server whether it has a newer schedule than the loaded one,
+// an error means it couldn't tell, not that the schedule is current
+func isTripsDataStale() (bool, error) {
+	stale, err := isScheduleOutdated(getScheduleFilename(), getScheduleLastModified())
+	if err != nil {
+		return false, fmt.Errorf("Could not check for trips data: %v", err)
+	}
+	return stale, nil
+}
+
+// requestSchedule sends a conditional request for the schedule, following redirects like the download does.
+// net/http keeps HEAD as HEAD and If-Modified-Since across redirects, so the headers are always the final response's,
+// e.g. those of the versioned file /latest redirects to rather than of the redirect itself
+func requestSchedule(method, lastModified string) (*http.Response, error) {
+	req, err := http.NewRequest(method, tripsDataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return httpClient.Do(req)
+}
+
+// isScheduleOutdated asks the server whether it has a different schedule than the one downloaded
+// with the given Content-Disposition and Last-Modified
+func isScheduleOutdated(filename, lastModified string) (bool, error) {
+	resp, err := requestSchedule(http.MethodHead, lastModified)
 	if err != nil {
-		log.Println("Could not check for trips data: ", err)
-		return false
+		return false, err
 	}
 	defer resp.Body.Close() // should be noop if there is no body
 
+	// some servers only answer GET, the body is closed unread so only the headers are transferred
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		resp.Body.Close()
+		if resp, err = requestSchedule(http.MethodGet, lastModified); err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	// otherwise an error page would look like a schedule without a filename
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Unexpected response code: %d from %s", resp.StatusCode, resp.Request.URL)
+	}
+
 	// should be in the form of:
 	//     attachment; filename=zet-gtfs-scheduled-000-00369.zip
 	contentDisposition := resp.Header.Get("Content-Disposition")
 	isAttachment := strings.HasPrefix(contentDisposition, "attachment; filename=zet-gtfs-scheduled")
-	matchesCachedValue := contentDisposition == cachedTripsFilename
-	return isAttachment && !matchesCachedValue
+	if isAttachment {
+		return contentDisposition != filename, nil
+	}
+
+	// without a versioned filename, a server ignoring If-Modified-Since can still say when the schedule changed
+	newLastModified := resp.Header.Get("Last-Modified")
+	return lastModified != "" && newLastModified != "" && newLastModified != lastModified, nil
+}
+
+// poller turns each feed into a snapshot, keeping what the polls need to know about the ones before
+type poller struct {
+	source feedSource
+	// when the feed suddenly went empty, to tell a glitch apart from the end of service
+	emptySince time.Time
+	// the latest snapshot pushed to the clients, which the movement gate compares to
+	lastPublished *Snapshot
 }
 
-func fetchTripsData() ([]byte, error) {
-	resp, err := http.Get(tripsDataURL)
+// poll fetches the next feed and publishes its snapshot, a feed which isn't newer or has no usable vehicles is skipped
+func (p *poller) poll() error {
+	data, feed, err := fetchFeed(p.source)
 	if err != nil {
-		log.Println("Could not fetch trips data: ", err)
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Could not read trips data response: ", err)
-		return nil, err
+	if feed.Header.Timestamp != nil {
+		headerTimestamp := *feed.Header.Timestamp
+		cachedTimestamp := atomic.LoadUint64(&lastUpdateTimestamp)
+		if newDataAvailable := cachedTimestamp < headerTimestamp; !newDataAvailable {
+			checkFeedRegression(cachedTimestamp, headerTimestamp)
+			return nil
+		}
+		atomic.StoreUint64(&lastUpdateTimestamp, headerTimestamp)
 	}
 
-	zipReader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	tickStart := time.Now()
+	vehicles, err := getVehiclesData(feed)
 	if err != nil {
-		log.Println("Could not create a zip reader for trips data: ", err)
-		return nil, err
+		log.Printf("Failed to get vehicles data: %v", err)
+		return nil
+	}
+	vehicles = dropStaleVehicles(vehicles, feed.GetHeader().GetTimestamp())
+
+	predictions := getTripPredictions(feed, time.Now())
+	newRoutes := getRoutes(vehicles, predictions)
+	logUnlabeled(newRoutes)
+	oldSnapshot := allVehicles.Load().(*Snapshot)
+
+	departedVehicles.Add(int64(len(carryOverVanished(oldSnapshot, newRoutes))))
+	calculateSpeeds(oldSnapshot, newRoutes)
+	newRoutes = calculateVehicleBearings(oldSnapshot, newRoutes)
+	updateOdometers(newRoutes, time.Now())
+	snapshot := newSnapshot(newRoutes, feed.GetHeader().GetTimestamp())
+	if *servePredicted {
+		snapshot.Predicted = getPredictedVehicles(newRoutes, predictions)
 	}
 
-	for _, zipFile := range zipReader.File {
-		if zipFile.Name == "trips.txt" {
-			unzippedFileBytes, err := readZipFile(zipFile)
-			if err != nil {
-				log.Println("Could not unzip trips.txt: ", err)
-				return nil, err
-			}
-			cachedTripsFilename = resp.Header.Get("Content-Disposition")
-			return unzippedFileBytes, nil
+	// an upstream hiccup can return a valid feed without any vehicles, which would blank the map
+	if snapshot.vehicleCount() == 0 && oldSnapshot.vehicleCount() >= minVehiclesBeforeEmptyFeed {
+		grace := time.Duration(getTunables().EmptyFeedGrace)
+		if p.emptySince.IsZero() {
+			p.emptySince = time.Now()
+			log.Printf("Feed has no vehicles, but the previous one had %d, keeping it for up to %v\n", oldSnapshot.vehicleCount(), grace)
+		}
+		if time.Since(p.emptySince) < grace {
+			return nil
 		}
+		log.Println("Feed has had no vehicles for longer than the grace period, clearing the map")
+	} else if snapshot.vehicleCount() > 0 {
+		p.emptySince = time.Time{}
+	}
+
+	// a snapshot held back from the streams is served with the sequence of the last one they got,
+	// the vehicles barely moved since
+	minMovement := getTunables().SnapshotMovement
+	publish := minMovement <= 0 || snapshot.changedSince(p.lastPublished, minMovement)
+	snapshot.Sequence = snapshotSequence.Load()
+	if publish {
+		snapshot.Sequence = snapshotSequence.Add(1)
 	}
-	return nil, errors.New("trips.txt not present in response")
+	// hashed here rather than by the first request, so the tick's time includes marshaling the vehicles
+	snapshot.contentHash()
+	allVehicles.Store(snapshot)
+	if publish {
+		vehicleBroadcaster.publish(snapshot)
+		p.lastPublished = snapshot
+	}
+	checkGeofences(snapshot)
+	recordHistory(snapshot)
+	stopArrivals.Store(getArrivals(feed))
+	serviceAlerts.Store(getAlertEntities(feed))
+	lastFeed.Store(data)
+	recordTickDuration(time.Since(tickStart))
+	return nil
 }
 
-func getTripsData() ([][]string, error) {
-	tripsData, err := fetchTripsData()
-	if err != nil {
-		log.Println("Could not open trips info: ", err)
+func main() {
+	flag.Parse()
+	if !strings.HasPrefix(*ssePath, "/") {
+		log.Fatalf("The SSE path has to start with a slash, got %q", *ssePath)
 	}
 
-	tripsReader := csv.NewReader(bytes.NewReader(tripsData))
+	if err := setUnits(*unitsName); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateMapView(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setUpDataDir(); err != nil {
+		log.Fatal(err)
+	}
+	if *staleAfter <= *pollInterval {
+		log.Printf("-stale-after of %v isn't longer than -poll-interval of %v, the feed will look stale between polls\n", *staleAfter, *pollInterval)
+	}
 
-	_, err = tripsReader.Read() // read the header
+	settings, err := loadTunables(*configPath)
 	if err != nil {
-		log.Printf("Could not parse CSV: %v\n", err)
+		log.Fatalf("Failed to load the config: %v", err)
 	}
+	tunables.Store(settings)
+	reloadConfigOnSIGHUP()
 
-	return tripsReader.ReadAll() // read the rest
-}
-
-func main() {
-	tripsData, err := getTripsData()
+	favicon = newStaticAsset(*faviconPath, embeddedFavicon)
+	indexTemplate, err = parseIndexTemplate(newStaticAsset(*indexPath, embeddedIndex))
 	if err != nil {
-		log.Println("Could not get trips data: ", err)
-		return
+		log.Fatalf("Failed to parse the map page: %v", err)
 	}
 
-	for _, row := range tripsData {
-		routeID := RouteID(row[0])
-		if _, exists := routesToTrips[routeID]; !exists {
-			routesToTrips[routeID] = Trips{}
+	// positions are served even without the schedule, they just won't have headsigns
+	if *noSchedule {
+		log.Println("Serving positions only, the schedule is never fetched")
+	} else {
+		if err := loadSchedule(); err != nil {
+			log.Println("Could not get trips data, serving vehicles without schedule data: ", err)
 		}
+		go scheduleCheck.run()
+	}
 
-		tripID := TripID(row[2])
-		routesToTrips[routeID][tripID] = Trip{Headsign: row[3], Direction: row[5]}
+	live := liveFeed{url: gtfsURL}
+	if *recordDir != "" {
+		live.recorder = newFeedRecorder(*recordDir, *recordKeep)
+	}
+	var source feedSource = live
+	if *replayDir != "" {
+		replay, err := newReplayFeed(*replayDir, *replaySpeed)
+		if err != nil {
+			log.Fatalf("Failed to load the recording: %v", err)
+		}
+		source = replay
 	}
 
-	feed, err := fetchGTFSRealTime(gtfsURL)
+	data, feed, err := fetchFeed(source)
 	if err != nil {
 		log.Fatalf("Failed to load initial data: %v", err)
 	}
@@ -372,48 +886,91 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load initial data: %v", err)
 	}
+	vehicles = dropStaleVehicles(vehicles, feed.GetHeader().GetTimestamp())
+
+	predictions := getTripPredictions(feed, time.Now())
+	routes := getRoutes(vehicles, predictions)
+	updateOdometers(routes, time.Now())
+	snapshot := newSnapshot(routes, feed.GetHeader().GetTimestamp())
+	if *servePredicted {
+		snapshot.Predicted = getPredictedVehicles(routes, predictions)
+	}
+	snapshot.Sequence = snapshotSequence.Add(1)
+	allVehicles.Store(snapshot)
+	stopArrivals.Store(getArrivals(feed))
+	serviceAlerts.Store(getAlertEntities(feed))
+	lastFeed.Store(data)
 
-	allVehicles.Store(getRoutes(vehicles))
-
+	p := &poller{source: source, lastPublished: allVehicles.Load().(*Snapshot)}
 	go func() {
 		for {
-			time.Sleep(2 * time.Second)
+			source.wait()
+			checkFeedAge()
 
-			feed, err := fetchGTFSRealTime(gtfsURL)
-			if err != nil {
-				log.Printf("Failed to fetch GTFS data: %v", err)
-				continue
-			}
-
-			if feed.Header.Timestamp != nil {
-				headerTimestamp := *feed.Header.Timestamp
-				cachedTimestamp := atomic.LoadUint64(&lastUpdateTimestamp)
-				if newDataAvailable := cachedTimestamp < headerTimestamp; !newDataAvailable {
-					continue
-				}
-				atomic.StoreUint64(&lastUpdateTimestamp, headerTimestamp)
+			err := p.poll()
+			if errors.Is(err, errReplayFinished) {
+				log.Println("Replay finished, the last snapshot stays served")
+				return
 			}
-
-			vehicles, err := getVehiclesData(feed)
 			if err != nil {
-				log.Printf("Failed to get vehicles data: %v", err)
-				continue
+				log.Printf("Failed to fetch GTFS data: %v", err)
 			}
-
-			newRoutes := getRoutes(vehicles)
-			oldRoutes := allVehicles.Load().(map[RouteID]Vehicles)
-
-			updatedRoutes := calculateVehicleBearings(oldRoutes, newRoutes)
-
-			allVehicles.Store(updatedRoutes)
 		}
 	}()
 
-	http.HandleFunc("/", mapHandler)
-	http.HandleFunc("/favicon.ico", faviconHandler)
-	// http.HandleFunc("/vehicles", vehicleHandler)
-	http.HandleFunc("/events", sseHandler)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", mapHandler)
+	mux.HandleFunc("/favicon.ico", faviconHandler)
+	mux.HandleFunc("/vehicles", limitRate(withServerTiming(vehicleHandler)))
+	mux.HandleFunc("GET /vehicles.ndjson", limitRate(ndjsonHandler))
+	mux.HandleFunc(*ssePath, sseHandler)
+	mux.HandleFunc("GET /ws", wsHandler)
+	mux.HandleFunc("GET /vehicles/{id}", vehicleByIDHandler)
+	mux.HandleFunc("GET /vehicles/count", vehicleCountHandler)
+	mux.HandleFunc("/gtfs-rt", gtfsRealtimeHandler)
+	mux.HandleFunc("GET /config", configHandler)
+	mux.HandleFunc("GET /readyz", readyHandler)
+	mux.HandleFunc("GET /healthz", healthHandler)
+	mux.HandleFunc("GET /stats", statsHandler)
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	mux.HandleFunc("GET /stop/{stop_id}/arrivals", arrivalsHandler)
+	mux.HandleFunc("GET /nearest-stops", nearestStopsHandler)
+	mux.HandleFunc("GET /history", limitRate(historyHandler))
+	mux.HandleFunc("GET /shapes/{id}", shapeHandler)
+	mux.HandleFunc("GET /routes/{id}/bunching", bunchingHandler)
+	mux.HandleFunc("GET /routes/{id}/headway", headwayHandler)
+	mux.HandleFunc("GET /routes/{id}/vehicles", routeVehiclesHandler)
+	mux.HandleFunc("GET /routes/{id}/shape", routeShapeHandler)
+	mux.HandleFunc("GET /bootstrap", limitRate(bootstrapHandler))
+	mux.HandleFunc("GET /routes", routesHandler)
+	mux.HandleFunc("GET /trips", limitRate(tripsHandler))
+	mux.HandleFunc("GET /routes/active", activeRoutesHandler)
+	mux.HandleFunc("GET /density", densityHandler)
+	mux.HandleFunc("GET /anomalies", anomaliesHandler)
+	mux.HandleFunc("GET /alerts", alertsHandler)
+	mux.HandleFunc("GET /openapi.json", openAPIHandler)
+
+	if *enableDebug {
+		mux.HandleFunc("GET /debug/feed", debugFeedHandler)
+		mux.HandleFunc("GET /debug/unresolved", debugUnresolvedHandler)
+		mux.HandleFunc("GET /debug/malformed", debugMalformedHandler)
+	}
+
+	if *adminToken != "" {
+		mux.Handle("/admin/", requireAdmin(adminMux))
+	}
 
-	log.Println("Server running on port 8080")
-	log.Fatal(http.ListenAndServe("0.0.0.0:8080", nil))
+	if *enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		mux.Handle("GET /debug/vars", expvar.Handler())
+	}
+
+	if config, err := json.Marshal(getEffectiveConfig()); err == nil {
+		log.Printf("Running with config: %s\n", config)
+	}
+	serve(logRequests(withTimeout(mux)))
 }
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	// what main sets up before serving anything, with the flags' defaults
+	settings := tunablesFromFlags()
+	tunables.Store(&settings)
+	allVehicles.Store(newSnapshot(map[RouteID]Vehicles{}, 0))
+	stopArrivals.Store(map[StopID][]Arrival{})
+
+	os.Exit(m.Run())
+}
+
+func TestCalculateVehicleBearings(t *testing.T) {
+	const lat, lon = 45.8131, 15.9772
+
+	type position struct {
+		routeID   RouteID
+		lat, lon  float32
+		direction int
+	}
+	tests := []struct {
+		name          string
+		before, after position
+		want          int
+	}{
+		{
+			name:  "first sighting",
+			after: position{"6", lat, lon, 45},
+			want:  45,
+		},
+		{
+			name:   "moved less than the move threshold",
+			before: position{"6", lat, lon, 90},
+			after:  position{"6", lat + 5e-6, lon + 5e-6, 0},
+			want:   90,
+		},
+		{
+			name:   "bearing changed less than the bearing threshold",
+			before: position{"6", lat, lon, 90},
+			after:  position{"6", lat + 0.001, lon - 0.00003, 0},
+			want:   90,
+		},
+		{
+			name:   "turned",
+			before: position{"6", lat, lon, 90},
+			after:  position{"6", lat, lon - 0.001, 0},
+			want:   180,
+		},
+		{
+			name:   "switched routes",
+			before: position{"14", lat, lon, 90},
+			after:  position{"6", lat, lon - 0.001, 30},
+			want:   30,
+		},
+		{
+			name:   "on a route that appeared this tick",
+			before: position{"6", lat, lon, 90},
+			after:  position{"268", lat, lon - 0.001, 30},
+			want:   30,
+		},
+	}
+
+	oldRoutes := map[RouteID]Vehicles{"14": {}}
+	newRoutes := map[RouteID]Vehicles{}
+	for _, tt := range tests {
+		if tt.before.routeID != "" {
+			oldRoutes[tt.before.routeID] = append(oldRoutes[tt.before.routeID], Vehicle{
+				ID: tt.name, Latitude: tt.before.lat, Longitude: tt.before.lon, Direction: tt.before.direction,
+			})
+		}
+		newRoutes[tt.after.routeID] = append(newRoutes[tt.after.routeID], Vehicle{
+			ID: tt.name, Latitude: tt.after.lat, Longitude: tt.after.lon, Direction: tt.after.direction,
+		})
+	}
+
+	updated := newSnapshot(calculateVehicleBearings(newSnapshot(oldRoutes, 1), newRoutes), 2)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, v, exists := updated.getVehicle(tt.name)
+			if !exists {
+				t.Fatal("vehicle is gone")
+			}
+			if v.Direction != tt.want {
+				t.Errorf("direction %d, want %d", v.Direction, tt.want)
+			}
+		})
+	}
+}
+
+// Zagreb landmarks for known-value tests
+var (
+	jelacicSquare = Point{Lat: 45.8131, Lon: 15.9772}
+	mainStation   = Point{Lat: 45.8050, Lon: 15.9784}
+	cathedral     = Point{Lat: 45.8150, Lon: 15.9819}
+)
+
+func TestCalculateBearing(t *testing.T) {
+	origin := Point{Lat: 45.8, Lon: 15.97}
+	tests := []struct {
+		name string
+		to   Point
+		want float64
+	}{
+		{"same point", origin, 0},
+		{"east", Point{Lat: 45.8, Lon: 15.98}, 0},
+		{"north", Point{Lat: 45.81, Lon: 15.97}, 90},
+		{"west", Point{Lat: 45.8, Lon: 15.96}, 180},
+		// atan2 is negative below the x axis, it's normalized into 0-360
+		{"south", Point{Lat: 45.79, Lon: 15.97}, 270},
+		{"south-east", Point{Lat: 45.79, Lon: 15.98}, 315},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateBearing(origin, tt.to); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("bearing %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// the angles are planar, in degrees of latitude and longitude
+	if got := calculateBearing(jelacicSquare, mainStation); math.Abs(got-278.43) > 0.01 {
+		t.Errorf("Jelačić square to the main station: %.2f, want 278.43", got)
+	}
+	if got := calculateBearing(jelacicSquare, cathedral); math.Abs(got-22.01) > 0.01 {
+		t.Errorf("Jelačić square to the cathedral: %.2f, want 22.01", got)
+	}
+}
+
+func TestCalculateDistance(t *testing.T) {
+	tests := []struct {
+		name   string
+		p1, p2 Point
+		want   float64
+	}{
+		{"same point", jelacicSquare, jelacicSquare, 0},
+		{"3-4-5", Point{Lat: 45, Lon: 15}, Point{Lat: 45.003, Lon: 15.004}, 0.005},
+		{"Jelačić square to the main station", jelacicSquare, mainStation, 0.0081884},
+		{"main station to Jelačić square", mainStation, jelacicSquare, 0.0081884},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateDistance(tt.p1, tt.p2); math.Abs(got-tt.want) > 1e-7 {
+				t.Errorf("distance %v°, want %v°", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkBuildRoutes compares building a large fleet's routes on a single worker to spreading it over all of them
+func BenchmarkBuildRoutes(b *testing.B) {
+	loadFixtureSchedule(b)
+	vehicles := fixtureFleet(b, 2000)
+
+	runs := []struct {
+		name  string
+		procs int
+	}{
+		{"serial", 1},
+		{"parallel", runtime.NumCPU()},
+	}
+	for _, run := range runs {
+		b.Run(run.name, func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(run.procs))
+			for b.Loop() {
+				buildRoutes(vehicles, nil)
+			}
+		})
+	}
+}
+
+// BenchmarkBuildRoutesPresized shows what sizing the routes after the previous snapshot saves in the steady state
+func BenchmarkBuildRoutesPresized(b *testing.B) {
+	loadFixtureSchedule(b)
+	// spread over as many routes as ZET runs, the fixture alone has two
+	vehicles := fixtureFleet(b, 2000)
+	for i, v := range vehicles {
+		v.Trip.RouteId = proto.String(strconv.Itoa(i % 150))
+	}
+	previous := allVehicles.Load().(*Snapshot)
+	b.Cleanup(func() { allVehicles.Store(previous) })
+
+	empty := newSnapshot(map[RouteID]Vehicles{}, 0)
+	routes, _ := buildRoutes(vehicles, nil)
+	runs := []struct {
+		name     string
+		snapshot *Snapshot
+	}{
+		{"first tick", empty},
+		{"steady state", newSnapshot(routes, 0)},
+	}
+	for _, run := range runs {
+		b.Run(run.name, func(b *testing.B) {
+			allVehicles.Store(run.snapshot)
+			b.ReportAllocs()
+			for b.Loop() {
+				buildRoutes(vehicles, nil)
+			}
+		})
+	}
+}
+
+// BenchmarkVehicleLookup compares finding each vehicle of the new feed in the previous snapshot
+// through its index to scanning the vehicle's route, which is what the bearing matcher used to do
+func BenchmarkVehicleLookup(b *testing.B) {
+	loadFixtureSchedule(b)
+	routes, _ := buildRoutes(fixtureFleet(b, 2000), nil)
+	old := newSnapshot(routes, 0)
+
+	b.Run("index", func(b *testing.B) {
+		for b.Loop() {
+			for _, vehicles := range routes {
+				for _, v := range vehicles {
+					if _, _, exists := old.getVehicle(v.ID); !exists {
+						b.Fatal("vehicle missing from the index")
+					}
+				}
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for b.Loop() {
+			for routeID, vehicles := range routes {
+				for _, v := range vehicles {
+					if slices.IndexFunc(old.Routes[routeID], func(o Vehicle) bool { return o.ID == v.ID }) == -1 {
+						b.Fatal("vehicle missing from its route")
+					}
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkCalculateVehicleBearings(b *testing.B) {
+	loadFixtureSchedule(b)
+	vehicles := fixtureFleet(b, 2000)
+	oldRoutes, _ := buildRoutes(vehicles, nil)
+	old := newSnapshot(oldRoutes, 0)
+	for _, v := range vehicles {
+		v.Position.Longitude = proto.Float32(v.Position.GetLongitude() - 0.001)
+	}
+	newRoutes, _ := buildRoutes(vehicles, nil)
+
+	for b.Loop() {
+		calculateVehicleBearings(old, newRoutes)
+	}
+}
+
+func TestGetVehiclesDataDeduplicates(t *testing.T) {
+	feed := &gtfs.FeedMessage{Entity: []*gtfs.FeedEntity{
+		fixtureVehicle("101", "6", "0_1_601", 45.8131, 15.9780, fixtureTimestamp+10),
+		fixtureVehicle("201", "14", "0_1_1401", 45.8020, 15.9900, fixtureTimestamp),
+		// a stale repeat of 101 after the fresh one, and a fresh one of 201 after the stale one
+		fixtureVehicle("101", "6", "0_1_601", 45.8131, 15.9700, fixtureTimestamp),
+		fixtureVehicle("201", "14", "0_1_1401", 45.8040, 15.9900, fixtureTimestamp+10),
+		// without IDs there's no telling they're the same vehicle
+		fixtureVehicle("", "6", "0_1_601", 45.81, 15.97, fixtureTimestamp),
+		fixtureVehicle("", "6", "0_1_601", 45.81, 15.97, fixtureTimestamp),
+		{Id: proto.String("alert")},
+	}}
+
+	vehicles, err := getVehiclesData(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vehicles) != 4 {
+		t.Fatalf("got %d vehicles, want 4", len(vehicles))
+	}
+	for _, v := range vehicles[:2] {
+		if v.GetTimestamp() != fixtureTimestamp+10 {
+			t.Errorf("vehicle %s kept from timestamp %d, want the newer %d", v.GetVehicle().GetId(), v.GetTimestamp(), fixtureTimestamp+10)
+		}
+	}
+}
+
+// degenerate inputs give 0, never NaN or an angle made up by rounding
+func TestCalculateBearingDegenerate(t *testing.T) {
+	negativeZero := math.Copysign(0, -1)
+	tests := []struct {
+		name   string
+		p1, p2 Point
+	}{
+		{"identical", jelacicSquare, jelacicSquare},
+		// atan2(0, -0) is 180
+		{"negative zero", Point{Lat: 0, Lon: 0}, Point{Lat: 0, Lon: negativeZero}},
+		{"NaN latitude", jelacicSquare, Point{Lat: math.NaN(), Lon: jelacicSquare.Lon}},
+		{"NaN longitude", Point{Lat: jelacicSquare.Lat, Lon: math.NaN()}, jelacicSquare},
+		{"both NaN", Point{Lat: math.NaN(), Lon: math.NaN()}, Point{Lat: math.NaN(), Lon: math.NaN()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calculateBearing(tt.p1, tt.p2); got != 0 {
+				t.Errorf("bearing %v, want 0", got)
+			}
+		})
+	}
+
+	// the smallest move a float32 position can make still has a direction in range
+	p2 := Point{Lat: jelacicSquare.Lat, Lon: float64(math.Nextafter32(float32(jelacicSquare.Lon), 0))}
+	if got := calculateBearing(jelacicSquare, p2); math.IsNaN(got) || got < 0 || got >= 360 {
+		t.Errorf("bearing of the smallest move %v, want one in [0, 360)", got)
+	}
+}
+
+// the schedule is served through redirects, the check has to judge the final response like the download does
+func TestIsScheduleOutdatedFollowsRedirects(t *testing.T) {
+	const (
+		current      = "attachment; filename=zet-gtfs-scheduled-000-00369.zip"
+		lastModified = "Wed, 14 Oct 2026 05:00:00 GMT"
+	)
+	tests := []struct {
+		name         string
+		refuseHead   bool
+		status       int
+		disposition  string
+		lastModified string
+		want         bool
+		wantErr      bool
+	}{
+		{name: "same schedule", status: http.StatusOK, disposition: current},
+		{name: "new schedule", status: http.StatusOK, disposition: "attachment; filename=zet-gtfs-scheduled-000-00370.zip", want: true},
+		{name: "not modified", status: http.StatusNotModified},
+		{name: "HEAD refused", refuseHead: true, status: http.StatusOK, disposition: "attachment; filename=zet-gtfs-scheduled-000-00370.zip", want: true},
+		{name: "newer without a filename", status: http.StatusOK, lastModified: "Thu, 15 Oct 2026 05:00:00 GMT", want: true},
+		{name: "error page", status: http.StatusNotFound, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var methods []string
+			mux := http.NewServeMux()
+			mux.Handle("/gtfs-scheduled/latest", http.RedirectHandler("/redirect", http.StatusFound))
+			mux.Handle("/redirect", http.RedirectHandler("/files/schedule.zip", http.StatusMovedPermanently))
+			mux.HandleFunc("/files/schedule.zip", func(w http.ResponseWriter, r *http.Request) {
+				methods = append(methods, r.Method)
+				if r.Header.Get("If-Modified-Since") != lastModified {
+					t.Errorf("%s without If-Modified-Since", r.Method)
+				}
+				if tt.refuseHead && r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				if tt.disposition != "" {
+					w.Header().Set("Content-Disposition", tt.disposition)
+				}
+				if tt.lastModified != "" {
+					w.Header().Set("Last-Modified", tt.lastModified)
+				}
+				w.WriteHeader(tt.status)
+			})
+			server := httptest.NewServer(mux)
+			t.Cleanup(server.Close)
+			previous := tripsDataURL
+			tripsDataURL = server.URL + "/gtfs-scheduled/latest"
+			t.Cleanup(func() { tripsDataURL = previous })
+
+			outdated, err := isScheduleOutdated(current, lastModified)
+			if (err != nil) != tt.wantErr || outdated != tt.want {
+				t.Errorf("got %v, %v, want %v and an error %v", outdated, err, tt.want, tt.wantErr)
+			}
+			wantMethods := []string{http.MethodHead}
+			if tt.refuseHead {
+				wantMethods = append(wantMethods, http.MethodGet)
+			}
+			if !slices.Equal(methods, wantMethods) {
+				t.Errorf("the schedule was requested with %v, want %v", methods, wantMethods)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fetched feeds which weren't valid protobuf, like a truncated download or a maintenance page served with a 200
+var malformedFeeds atomic.Int64
+
+type MalformedFeed struct {
+	SeenAt string `json:"seen_at"`
+	Size   int    `json:"size"`
+	Error  string `json:"error"`
+	// the first malformedPreviewSize bytes of the response
+	Hex   string `json:"hex"`
+	ASCII string `json:"ascii"`
+}
+
+const (
+	malformedPreviewSize = 256
+	malformedSampleSize  = 10
+)
+
+// malformedSample keeps the latest malformed feeds, oldest first
+var malformedSample = struct {
+	sync.Mutex
+	feeds []MalformedFeed
+}{}
+
+// printablePreview replaces everything that isn't printable ASCII with a dot, like hexdump -C does
+func printablePreview(data []byte) string {
+	var sb strings.Builder
+	for _, b := range data {
+		if b >= 0x20 && b < 0x7f {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteByte('.')
+		}
+	}
+	return sb.String()
+}
+
+func recordMalformedFeed(data []byte, err error) {
+	malformedFeeds.Add(1)
+
+	preview := data[:min(len(data), malformedPreviewSize)]
+	log.Printf("Feed of %d bytes isn't valid protobuf, it starts with %q\n", len(data), printablePreview(preview[:min(len(preview), 64)]))
+
+	malformedSample.Lock()
+	defer malformedSample.Unlock()
+	if len(malformedSample.feeds) == malformedSampleSize {
+		malformedSample.feeds = malformedSample.feeds[1:]
+	}
+	malformedSample.feeds = append(malformedSample.feeds, MalformedFeed{
+		SeenAt: formatZagrebTime(time.Now().Unix()),
+		Size:   len(data),
+		Error:  err.Error(),
+		Hex:    hex.EncodeToString(preview),
+		ASCII:  printablePreview(preview),
+	})
+}
+
+func getMalformedSample() []MalformedFeed {
+	malformedSample.Lock()
+	defer malformedSample.Unlock()
+	return append([]MalformedFeed{}, malformedSample.feeds...)
+}
+
+func debugMalformedHandler(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Total  int64           `json:"total"`
+		Sample []MalformedFeed `json:"sample"`
+	}{
+		Total:  malformedFeeds.Load(),
+		Sample: getMalformedSample(),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
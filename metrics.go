@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// realtime route or trip IDs which weren't in a schedule known to be current
+var unresolvedIDs atomic.Int64
+
+type UnresolvedID struct {
+	RouteID RouteID `json:"route_id"`
+	TripID  TripID  `json:"trip_id"`
+	SeenAt  string  `json:"seen_at"`
+}
+
+const unresolvedSampleSize = 50
+
+// unresolvedSample keeps the latest unresolved IDs, oldest first
+var unresolvedSample = struct {
+	sync.Mutex
+	ids []UnresolvedID
+}{}
+
+func recordUnresolved(routeID RouteID, tripID TripID) {
+	unresolvedIDs.Add(1)
+
+	unresolvedSample.Lock()
+	defer unresolvedSample.Unlock()
+	if len(unresolvedSample.ids) == unresolvedSampleSize {
+		unresolvedSample.ids = unresolvedSample.ids[1:]
+	}
+	unresolvedSample.ids = append(unresolvedSample.ids, UnresolvedID{
+		RouteID: routeID,
+		TripID:  tripID,
+		SeenAt:  formatZagrebTime(time.Now().Unix()),
+	})
+}
+
+func getUnresolvedSample() []UnresolvedID {
+	unresolvedSample.Lock()
+	defer unresolvedSample.Unlock()
+	return append([]UnresolvedID{}, unresolvedSample.ids...)
+}
+
+func debugUnresolvedHandler(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Total  int64          `json:"total"`
+		Sample []UnresolvedID `json:"sample"`
+	}{
+		Total:  unresolvedIDs.Load(),
+		Sample: getUnresolvedSample(),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
+
+// histogram counts observations into cumulative buckets, like a Prometheus client would
+type histogram struct {
+	sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	h.Lock()
+	defer h.Unlock()
+	i := 0
+	for i < len(h.bounds) && value > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.Lock()
+	defer h.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, h.count, name, h.sum, name, h.count)
+}
+
+// tickDurations is how long each poll tick spends turning a feed into a published snapshot, in seconds,
+// once it gets close to the poll interval the single poll goroutine can't keep up
+var tickDurations = newHistogram(0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5)
+
+// the latest tick's duration in nanoseconds
+var lastTickDuration atomic.Int64
+
+func recordTickDuration(d time.Duration) {
+	tickDurations.observe(d.Seconds())
+	lastTickDuration.Store(int64(d))
+}
+
+func writeMetric(w io.Writer, name, metricType, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, metricType, name, value)
+}
+
+// metricsHandler serves the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := getStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "zet_uptime_seconds", "gauge", "Seconds since the server started.", stats.UptimeSeconds)
+	writeMetric(w, "zet_vehicles_tracked", "gauge", "Vehicles in the latest snapshot.", stats.TotalVehicles)
+	writeMetric(w, "zet_routes_active", "gauge", "Routes with at least one vehicle in the latest snapshot.", stats.ActiveRoutes)
+	writeMetric(w, "zet_sse_clients", "gauge", "Connected SSE clients.", stats.SSEClients)
+	writeMetric(w, "zet_feed_last_update_timestamp_seconds", "gauge", "Timestamp of the latest feed.", stats.LastUpdate)
+	writeMetric(w, "zet_feed_fetches_succeeded_total", "counter", "Realtime feed fetches which parsed.", stats.FeedFetchesSucceeded)
+	writeMetric(w, "zet_feed_fetches_failed_total", "counter", "Realtime feed fetches which failed or didn't parse.", stats.FeedFetchesFailed)
+	writeMetric(w, "zet_feed_malformed_total", "counter", "Realtime feed fetches which weren't valid protobuf, also counted as failed.", stats.FeedMalformed)
+	writeMetric(w, "zet_feed_timestamp_regressions_total", "counter", "Realtime feeds older than the latest one, skipped.", stats.FeedRegressions)
+	writeMetric(w, "zet_unresolved_ids_total", "counter", "Realtime route or trip IDs missing from a current schedule.", stats.UnresolvedIDs)
+	writeMetric(w, "zet_vehicles_departed_total", "counter", "Vehicles removed after missing from the feed for longer than the grace period.", stats.VehiclesDeparted)
+	tickDurations.write(w, "zet_tick_processing_seconds", "Time from a fetched feed to its snapshot being published, excluding the fetch.")
+}
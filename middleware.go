@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var handlerTimeout = flag.Duration("handler-timeout", 15*time.Second, "how long a request may take before it's cancelled with a 503, streams are exempt")
+
+var logExclude = flag.String("log-exclude", "/healthz,/readyz", "comma separated paths left out of the access log")
+
+// withTimeout cancels requests which take longer than the handler timeout,
+// except for the long-lived streams and profiles which are meant to run that long,
+// and a forced schedule refresh which waits for the whole download
+func withTimeout(next http.Handler) http.Handler {
+	limited := http.TimeoutHandler(next, *handlerTimeout, "Request timed out")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streaming := r.URL.Path == *ssePath ||
+			r.URL.Path == "/ws" ||
+			r.URL.Path == "/vehicles.ndjson" ||
+			r.URL.Path == "/trips" ||
+			r.URL.Path == "/admin/refresh-schedule" ||
+			strings.HasPrefix(r.URL.Path, "/debug/pprof/")
+		if streaming || *handlerTimeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers what the handler responded with, for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.size += n
+	return n, err
+}
+
+// Flush keeps SSE working through the wrapper
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests writes an access log line for every request, SSE connections are logged when opened and when closed
+func logRequests(next http.Handler) http.Handler {
+	excluded := map[string]bool{}
+	for _, path := range strings.Split(*logExclude, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			excluded[path] = true
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if excluded[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		isSSE := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		if isSSE {
+			log.Printf("%s %s %s SSE connection opened\n", r.RemoteAddr, r.Method, r.URL.RequestURI())
+		}
+
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK // nothing written, net/http sends an empty 200
+		}
+
+		if isSSE {
+			log.Printf("%s %s %s SSE connection closed after %v, %d %d bytes\n", r.RemoteAddr, r.Method, r.URL.RequestURI(), time.Since(start).Round(time.Second), recorder.status, recorder.size)
+			return
+		}
+		log.Printf("%s %s %s %d %d bytes %v\n", r.RemoteAddr, r.Method, r.URL.RequestURI(), recorder.status, recorder.size, time.Since(start))
+	})
+}
+
+// timingWriter adds a Server-Timing header just before the response starts, with how long the handler took to get there,
+// split into the phases the handler marked with markTiming
+type timingWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	lastMark    time.Time
+	phases      []string
+	wroteHeader bool
+}
+
+func (t *timingWriter) WriteHeader(status int) {
+	if !t.wroteHeader {
+		t.wroteHeader = true
+		if len(t.phases) > 0 {
+			t.mark("encode")
+		}
+		t.phases = append(t.phases, fmt.Sprintf("total;dur=%.3f", float64(time.Since(t.start).Microseconds())/1000))
+		t.Header().Set("Server-Timing", strings.Join(t.phases, ", "))
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *timingWriter) Write(data []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	return t.ResponseWriter.Write(data)
+}
+
+func (t *timingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func (t *timingWriter) mark(phase string) {
+	now := time.Now()
+	t.phases = append(t.phases, fmt.Sprintf("%s;dur=%.3f", phase, float64(now.Sub(t.lastMark).Microseconds())/1000))
+	t.lastMark = now
+}
+
+// markTiming ends a phase of the Server-Timing header, e.g. "build" once the payload is ready,
+// whatever comes after the last mark up to the first byte is reported as "encode"
+func markTiming(w http.ResponseWriter, phase string) {
+	if t, ok := w.(*timingWriter); ok && !t.wroteHeader {
+		t.mark(phase)
+	}
+}
+
+// withServerTiming reports the handler's timings in the browser's devtools
+func withServerTiming(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		next(&timingWriter{ResponseWriter: w, start: now, lastMark: now}, r)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+// ndjsonHandler streams one vehicle per line, so consumers can process the fleet incrementally
+func ndjsonHandler(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseVehicleFilters(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	routes := applyFilters(allVehicles.Load().(*Snapshot).Routes, filters)
+
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	flusher, _ := w.(http.Flusher)
+
+	// Encode terminates every value with a newline
+	encoder := json.NewEncoder(newCappedWriter(w))
+	for _, routeID := range slices.SortedFunc(maps.Keys(routes), compareRouteIDs) {
+		for _, v := range routes[routeID] {
+			line := struct {
+				RouteID RouteID `json:"route_id"`
+				Vehicle
+			}{
+				RouteID: routeID,
+				Vehicle: v,
+			}
+			if err := encoder.Encode(line); err != nil {
+				return // the client went away
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
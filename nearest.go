@@ -0,0 +1,66 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+)
+
+const (
+	defaultNearestStops = 5
+	maxNearestStops     = 50
+)
+
+type NearbyStop struct {
+	Stop
+	Distance Distance `json:"distance"` // as the crow flies, walking is somewhat longer
+}
+
+// findNearestStops returns up to limit stops closest to p, closest first
+func findNearestStops(p Point, limit int) []NearbyStop {
+	mu.RLock()
+	nearby := make([]NearbyStop, 0, len(schedule.Stops))
+	for _, stop := range schedule.Stops {
+		nearby = append(nearby, NearbyStop{Stop: stop, Distance: Distance(geoDistance(p, Point{Lat: stop.Lat, Lon: stop.Lon}))})
+	}
+	mu.RUnlock()
+
+	slices.SortFunc(nearby, func(a, b NearbyStop) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+	return nearby[:min(limit, len(nearby))]
+}
+
+func nearestStopsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("lat") == "" || query.Get("lon") == "" {
+		writeJSONError(w, http.StatusBadRequest, "lat and lon are required")
+		return
+	}
+	lat, err := queryFloat(query, "lat", 0, -90, 90)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lon, err := queryFloat(query, "lon", 0, -180, 180)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := queryInt(query, "limit", defaultNearestStops, 1, maxNearestStops)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := struct {
+		Stops []NearbyStop `json:"stops"`
+		Units Units        `json:"units"`
+	}{
+		Units: apiUnits(),
+		Stops: findNearestStops(Point{Lat: lat, Lon: lon}, limit),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
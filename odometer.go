@@ -0,0 +1,59 @@
+package main
+
+import "time"
+
+// a vehicle missing from the feed for longer than this starts from zero when it comes back
+const odometerForgetAfter = 30 * time.Minute
+
+// night lines run past midnight, so the service day rolls over in the early morning
+const serviceDayCutoff = 4 * time.Hour
+
+type odometer struct {
+	meters float64
+	// where the distance was last measured from, it only moves once the vehicle really does
+	position   Point
+	lastSeen   time.Time
+	serviceDay string
+}
+
+// only touched from the goroutine polling the feed
+var odometers = map[string]*odometer{}
+
+func getServiceDay(now time.Time) string {
+	return now.In(zagreb).Add(-serviceDayCutoff).Format("20060102")
+}
+
+// updateOdometers accumulates the distance every vehicle covered since the previous update and sets OdometerMeters,
+// jitter below the move threshold and jumps above the teleport threshold don't count
+func updateOdometers(routes map[RouteID]Vehicles, now time.Time) {
+	settings := getTunables()
+	serviceDay := getServiceDay(now)
+
+	for routeID, vehicles := range routes {
+		for i, v := range vehicles {
+			position := Point{Lat: float64(v.Latitude), Lon: float64(v.Longitude)}
+
+			o, exists := odometers[v.ID]
+			if !exists || o.serviceDay != serviceDay || now.Sub(o.lastSeen) > odometerForgetAfter {
+				o = &odometer{position: position, serviceDay: serviceDay}
+				odometers[v.ID] = o
+			}
+			o.lastSeen = now
+
+			if calculateDistance(o.position, position) >= settings.MoveThreshold {
+				if distance := geoDistance(o.position, position); distance <= settings.TeleportThreshold {
+					o.meters += distance
+				}
+				o.position = position
+			}
+
+			routes[routeID][i].OdometerMeters = Distance(o.meters)
+		}
+	}
+
+	for id, o := range odometers {
+		if now.Sub(o.lastSeen) > odometerForgetAfter {
+			delete(odometers, id)
+		}
+	}
+}
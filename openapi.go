@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// apiParam is a path or query parameter of an endpoint
+type apiParam struct {
+	name        string
+	in          string // "path" or "query"
+	kind        string // the parameter's JSON schema type
+	description string
+}
+
+func pathParam(name, description string) apiParam {
+	return apiParam{name: name, in: "path", kind: "string", description: description}
+}
+
+func queryParam(name, kind, description string) apiParam {
+	return apiParam{name: name, in: "query", kind: kind, description: description}
+}
+
+// apiEndpoint describes a handler for the OpenAPI document, response is a value of the type the handler encodes,
+// nil for responses which aren't JSON
+type apiEndpoint struct {
+	method      string
+	path        string
+	summary     string
+	params      []apiParam
+	response    any
+	contentType string // when the response isn't JSON
+	admin       bool
+}
+
+// apiEndpoints mirrors the handlers registered in main, the response types are the ones the handlers encode,
+// so adding a field to e.g. Vehicle shows up in the document by itself
+func apiEndpoints() []apiEndpoint {
+	idParam := pathParam("id", "route ID")
+	formatParam := queryParam("format", "string", "array (default) or polyline, an encoded polyline instead of the points")
+
+	endpoints := []apiEndpoint{
+		{method: "GET", path: "/vehicles", summary: "Vehicles by route, GeoJSON instead with Accept: application/geo+json",
+			params: []apiParam{
+				queryParam("route", "string", "comma separated route IDs"),
+				queryParam("type", "string", "tram or bus"),
+				queryParam("headsign", "string", "vehicles whose headsign contains it, ignoring case and diacritics"),
+				queryParam("wheelchair", "string", "yes, no or unknown"),
+				queryParam("minSpeed", "number", "km/h below which vehicles are left out, vehicles without a known speed stay in"),
+				queryParam("fields", "string", "comma separated vehicle fields to keep, unknown ones are named in a Warning header"),
+			},
+			response: struct {
+				Vehicles          map[RouteID]Vehicles `json:"vehicles"`
+				Predicted         []PredictedVehicle   `json:"predicted,omitempty"`
+				TotalVehicles     int                  `json:"total_vehicles"`
+				TotalRoutes       int                  `json:"total_routes"`
+				ScheduleAvailable bool                 `json:"schedule_available"`
+				Stale             bool                 `json:"stale"`
+				Sequence          uint64               `json:"sequence"`
+				Units             Units                `json:"units"`
+			}{}},
+		{method: "GET", path: "/vehicles.ndjson", summary: "Every vehicle as a line of JSON with its route_id", contentType: contentTypeNDJSON},
+		{method: "GET", path: *ssePath, summary: "Server-sent events with every new snapshot", contentType: "text/event-stream",
+			params: []apiParam{
+				queryParam("mode", "string", "delta for only what changed, counts for vehicles per route"),
+				queryParam("encoding", "string", "json (default) or binary, see encodeBinarySnapshot"),
+				queryParam("legacy", "string", "1 for unnamed events with just the vehicles"),
+			}},
+		{method: "GET", path: "/ws", summary: "WebSocket with every new snapshot, filters are sent as messages", contentType: contentTypeJSON},
+		{method: "GET", path: "/vehicles/{id}", summary: "A single vehicle", params: []apiParam{pathParam("id", "vehicle ID")},
+			response: struct {
+				RouteID RouteID `json:"route_id"`
+				Vehicle Vehicle `json:"vehicle"`
+				Units   Units   `json:"units"`
+			}{}},
+		{method: "GET", path: "/vehicles/count", summary: "Vehicles in total and per route",
+			response: struct {
+				Total    int         `json:"total"`
+				PerRoute routeCounts `json:"per_route"`
+			}{}},
+		{method: "GET", path: "/gtfs-rt", summary: "The latest GTFS Realtime feed as received", contentType: "application/x-protobuf"},
+		{method: "GET", path: "/config", summary: "The settings the server runs with", response: EffectiveConfig{}},
+		{method: "GET", path: "/readyz", summary: "Ready once the first feed is in",
+			response: struct {
+				Ready             bool `json:"ready"`
+				ScheduleAvailable bool `json:"schedule_available"`
+			}{}},
+		{method: "GET", path: "/healthz", summary: "Fails once the feed is older than -stale-after",
+			response: struct {
+				Healthy    bool    `json:"healthy"`
+				FeedAge    float64 `json:"feed_age_seconds"`
+				StaleAfter float64 `json:"stale_after_seconds"`
+			}{}},
+		{method: "GET", path: "/stats", summary: "Counters and the state of the feed", response: Stats{}},
+		{method: "GET", path: "/metrics", summary: "Prometheus metrics", contentType: "text/plain"},
+		{method: "GET", path: "/stop/{stop_id}/arrivals", summary: "Predicted arrivals at a stop",
+			params: []apiParam{pathParam("stop_id", "stop ID"), queryParam("limit", "integer", "most arrivals returned")},
+			response: struct {
+				Arrivals []Arrival `json:"arrivals"`
+			}{}},
+		{method: "GET", path: "/nearest-stops", summary: "The stops closest to a point",
+			params: []apiParam{queryParam("lat", "number", "latitude"), queryParam("lon", "number", "longitude"), queryParam("limit", "integer", "most stops returned")},
+			response: struct {
+				Stops []NearbyStop `json:"stops"`
+				Units Units        `json:"units"`
+			}{}},
+		{method: "GET", path: "/history", summary: "Where a vehicle has been within -history-retention",
+			params: []apiParam{
+				queryParam("vehicle", "string", "vehicle ID"),
+				queryParam("from", "integer", "unix seconds"),
+				queryParam("to", "integer", "unix seconds"),
+				formatParam,
+			},
+			response: struct {
+				VehicleID string          `json:"vehicle_id"`
+				Points    *[]HistoryPoint `json:"points,omitempty"`
+				Polyline  *string         `json:"polyline,omitempty"`
+			}{}},
+		{method: "GET", path: "/shapes/{id}", summary: "A shape's geometry", params: []apiParam{pathParam("id", "shape ID"), formatParam},
+			response: struct {
+				ShapeGeometry
+				Units Units `json:"units"`
+			}{}},
+		{method: "GET", path: "/routes/{id}/bunching", summary: "Consecutive vehicles closer than the bunching threshold", params: []apiParam{idParam},
+			response: struct {
+				RouteID   RouteID       `json:"route_id"`
+				Threshold Distance      `json:"threshold"`
+				Pairs     []BunchedPair `json:"pairs"`
+				Units     Units         `json:"units"`
+			}{}},
+		{method: "GET", path: "/routes/{id}/headway", summary: "Estimated headways per direction", params: []apiParam{idParam},
+			response: struct {
+				RouteID      RouteID            `json:"route_id"`
+				AverageSpeed Speed              `json:"assumed_speed"`
+				Directions   []DirectionHeadway `json:"directions"`
+				Units        Units              `json:"units"`
+			}{}},
+		{method: "GET", path: "/routes/{id}/vehicles", summary: "A route with its vehicles", params: []apiParam{idParam},
+			response: struct {
+				Route    RouteInfo `json:"route"`
+				Vehicles Vehicles  `json:"vehicles"`
+				Units    Units     `json:"units"`
+			}{}},
+		{method: "GET", path: "/routes/{id}/shape", summary: "A route's shapes by direction", params: []apiParam{idParam, formatParam},
+			response: struct {
+				RouteID    RouteID                 `json:"route_id"`
+				Directions map[string][]RouteShape `json:"directions"`
+				Units      Units                   `json:"units"`
+			}{}},
+		{method: "GET", path: "/bootstrap", summary: "Everything the map needs to start", params: []apiParam{queryParam("stops", "string", "0 to leave the stops out")},
+			response: struct {
+				ScheduleVersion  string               `json:"schedule_version"`
+				ScheduleFilename string               `json:"schedule_filename"`
+				Routes           []RouteInfo          `json:"routes"`
+				Stops            []Stop               `json:"stops,omitempty"`
+				Vehicles         map[RouteID]Vehicles `json:"vehicles"`
+				TotalVehicles    int                  `json:"total_vehicles"`
+				TotalRoutes      int                  `json:"total_routes"`
+				Timestamp        uint64               `json:"timestamp"`
+				Units            Units                `json:"units"`
+			}{}},
+		{method: "GET", path: "/routes", summary: "Every route in the schedule",
+			response: struct {
+				ScheduleVersion string      `json:"schedule_version"`
+				Routes          []RouteInfo `json:"routes"`
+			}{}},
+		{method: "GET", path: "/trips", summary: "Every trip in the schedule by route",
+			params: []apiParam{queryParam("route", "string", "only this route's trips"), queryParam("pretty", "string", "1 to indent")},
+			response: struct {
+				ScheduleFilename string                          `json:"schedule_filename"`
+				Trips            map[RouteID]map[TripID]TripInfo `json:"trips"`
+			}{}},
+		{method: "GET", path: "/routes/active", summary: "Routes with at least one vehicle",
+			response: struct {
+				Routes []ActiveRoute `json:"routes"`
+			}{}},
+		{method: "GET", path: "/density", summary: "Vehicles per grid cell", params: []apiParam{queryParam("cellSize", "number", "cell size in meters")},
+			response: struct {
+				CellSize float64       `json:"cell_size"`
+				Cells    []DensityCell `json:"cells"`
+			}{}},
+		{method: "GET", path: "/anomalies", summary: "Vehicles which jumped further than the teleport threshold",
+			response: struct {
+				Threshold Distance  `json:"threshold"`
+				Anomalies []Anomaly `json:"anomalies"`
+				Units     Units     `json:"units"`
+			}{}},
+		{method: "GET", path: "/alerts", summary: "Service alerts, the feed's own entities with format=gtfs",
+			params: []apiParam{queryParam("format", "string", "gtfs for the GTFS Realtime entities as JSON")},
+			response: struct {
+				Alerts []Alert `json:"alerts"`
+			}{}},
+	}
+
+	if *adminToken != "" {
+		endpoints = append(endpoints, apiEndpoint{method: "POST", path: "/admin/refresh-schedule", summary: "Load a new schedule right away if there is one", admin: true,
+			response: struct {
+				Reloaded        bool   `json:"reloaded"`
+				ScheduleVersion string `json:"schedule_version"`
+			}{}})
+	}
+	return endpoints
+}
+
+// schemaGenerator turns Go types into JSON schemas the way encoding/json would encode them,
+// named structs are put in the components and referenced
+type schemaGenerator struct {
+	components map[string]any
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	// types encoding themselves, the rest follows from the kind
+	switch t {
+	case reflect.TypeFor[Speed](), reflect.TypeFor[Distance]():
+		return map[string]any{"type": "number", "description": "in the response's units"}
+	case reflect.TypeFor[Duration]():
+		return map[string]any{"type": "string", "example": "2s"}
+	case reflect.TypeFor[routeCounts]():
+		return map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "integer"}}
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{}
+	}
+	if t.Implements(textMarshalerType) && !t.Implements(jsonMarshalerType) {
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, exists := g.components[t.Name()]; !exists {
+			g.components[t.Name()] = map[string]any{} // a placeholder in case the type refers to itself
+			g.components[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for _, field := range reflect.VisibleFields(t) {
+		tag := field.Tag.Get("json")
+		// embedded structs without a name of their own are flattened, like encoding/json does
+		if !field.IsExported() || tag == "-" || (field.Anonymous && tag == "") {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIDocument describes the API in OpenAPI 3, generated from apiEndpoints and the Go types
+func openAPIDocument() map[string]any {
+	generator := &schemaGenerator{components: map[string]any{}}
+	errorResponse := map[string]any{
+		"description": "writeJSONError's envelope",
+		"content": map[string]any{contentTypeJSON: map[string]any{"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{"error": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"code": map[string]any{"type": "string"}, "message": map[string]any{"type": "string"}},
+				"required":   []string{"code", "message"},
+			}},
+			"required": []string{"error"},
+		}}},
+	}
+
+	paths := map[string]any{}
+	for _, endpoint := range apiEndpoints() {
+		content := map[string]any{endpoint.contentType: map[string]any{}}
+		if endpoint.response != nil {
+			content = map[string]any{contentTypeJSON: map[string]any{"schema": generator.schema(reflect.TypeOf(endpoint.response))}}
+		}
+
+		parameters := []any{}
+		for _, param := range endpoint.params {
+			parameters = append(parameters, map[string]any{
+				"name":        param.name,
+				"in":          param.in,
+				"required":    param.in == "path",
+				"description": param.description,
+				"schema":      map[string]any{"type": param.kind},
+			})
+		}
+
+		operation := map[string]any{
+			"summary":    endpoint.summary,
+			"parameters": parameters,
+			"responses": map[string]any{
+				"200":     map[string]any{"description": "OK", "content": content},
+				"default": errorResponse,
+			},
+		}
+		if endpoint.admin {
+			operation["security"] = []any{map[string]any{"adminToken": []string{}}}
+		}
+
+		if _, exists := paths[endpoint.path]; !exists {
+			paths[endpoint.path] = map[string]any{}
+		}
+		paths[endpoint.path].(map[string]any)[strings.ToLower(endpoint.method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "ZET live map",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": generator.components,
+			"securitySchemes": map[string]any{
+				"adminToken": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(openAPIDocument())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// getOpenAPIDocument fetches the document the way a client would, as plain JSON
+func getOpenAPIDocument(t *testing.T) map[string]any {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	openAPIHandler(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d", recorder.Code)
+	}
+	var document map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatalf("the document isn't JSON: %v", err)
+	}
+	return document
+}
+
+// collectRefs returns every $ref in the value, however deep
+func collectRefs(value any) []string {
+	refs := []string{}
+	switch value := value.(type) {
+	case map[string]any:
+		for key, v := range value {
+			if ref, ok := v.(string); ok && key == "$ref" {
+				refs = append(refs, ref)
+			}
+			refs = append(refs, collectRefs(v)...)
+		}
+	case []any:
+		for _, v := range value {
+			refs = append(refs, collectRefs(v)...)
+		}
+	}
+	return refs
+}
+
+func TestOpenAPIRefsResolve(t *testing.T) {
+	document := getOpenAPIDocument(t)
+	if document["openapi"] != "3.0.3" {
+		t.Errorf("openapi %v, want 3.0.3", document["openapi"])
+	}
+	schemas := document["components"].(map[string]any)["schemas"].(map[string]any)
+
+	refs := collectRefs(document)
+	if len(refs) == 0 {
+		t.Fatal("no $ref in the document, the named types aren't put in the components")
+	}
+	for _, ref := range refs {
+		name, found := strings.CutPrefix(ref, "#/components/schemas/")
+		if _, exists := schemas[name]; !found || !exists {
+			t.Errorf("%s doesn't resolve", ref)
+		}
+	}
+
+	for name, schema := range schemas {
+		properties, _ := schema.(map[string]any)["properties"].(map[string]any)
+		required, _ := schema.(map[string]any)["required"].([]any)
+		for _, property := range required {
+			if _, exists := properties[property.(string)]; !exists {
+				t.Errorf("%s requires %v, which isn't one of its properties", name, property)
+			}
+		}
+	}
+
+	// the schemas follow the types, a vehicle's fields are there without being listed anywhere
+	vehicle := schemas["Vehicle"].(map[string]any)["properties"].(map[string]any)
+	for _, field := range []string{"id", "lat", "lon", "speed", "wheelchair_accessible"} {
+		if _, exists := vehicle[field]; !exists {
+			t.Errorf("Vehicle has no %q property", field)
+		}
+	}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+func TestOpenAPIPathParamsDeclared(t *testing.T) {
+	paths := getOpenAPIDocument(t)["paths"].(map[string]any)
+	if len(paths) == 0 {
+		t.Fatal("no paths")
+	}
+
+	for path, operations := range paths {
+		inPath := []string{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+			inPath = append(inPath, match[1])
+		}
+		slices.Sort(inPath)
+
+		for method, operation := range operations.(map[string]any) {
+			declared := []string{}
+			for _, param := range operation.(map[string]any)["parameters"].([]any) {
+				param := param.(map[string]any)
+				if param["in"] != "path" {
+					continue
+				}
+				declared = append(declared, param["name"].(string))
+				if param["required"] != true {
+					t.Errorf("%s %s: path parameter %v isn't required", method, path, param["name"])
+				}
+			}
+			slices.Sort(declared)
+			if !slices.Equal(declared, inPath) {
+				t.Errorf("%s %s: declares path parameters %v, the path has %v", method, path, declared, inPath)
+			}
+		}
+	}
+}
+
+func TestOpenAPIAdminEndpoints(t *testing.T) {
+	const refresh = "/admin/refresh-schedule"
+	if _, exists := getOpenAPIDocument(t)["paths"].(map[string]any)[refresh]; exists {
+		t.Errorf("%s is documented without an admin token", refresh)
+	}
+
+	previous := *adminToken
+	*adminToken = "secret"
+	t.Cleanup(func() { *adminToken = previous })
+
+	operations, exists := getOpenAPIDocument(t)["paths"].(map[string]any)[refresh]
+	if !exists {
+		t.Fatalf("%s isn't documented with an admin token", refresh)
+	}
+	if _, secured := operations.(map[string]any)["post"].(map[string]any)["security"]; !secured {
+		t.Errorf("%s doesn't require the admin token", refresh)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// writeJSONError responds with {"error": {"code": "not_found", "message": "..."}}, the code being the status in snake case,
+// so API clients can handle every data endpoint's errors the same way.
+// The static assets keep http.ServeFile's plain text errors and a request over -handler-timeout gets
+// http.TimeoutHandler's plain text 503, both are for browsers rather than API clients.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	type apiError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	json.NewEncoder(w).Encode(struct {
+		Error apiError `json:"error"`
+	}{
+		Error: apiError{
+			Code:    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+			Message: message,
+		},
+	})
+}
+
+// queryInt returns the named integer parameter, or the fallback if it's missing,
+// anything non-numeric or outside [min, max] is an error describing what's expected
+func queryInt(query url.Values, name string, fallback, min, max int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be a whole number between %d and %d, got %q", name, min, max, value)
+	}
+	return n, nil
+}
+
+// queryFloat is queryInt for decimal numbers
+func queryFloat(query url.Values, name string, fallback, min, max float64) (float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	// NaN compares false to everything, so it would slip through the range check
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n < min || n > max {
+		return 0, fmt.Errorf("%s must be a number between %g and %g, got %q", name, min, max, value)
+	}
+	return n, nil
+}
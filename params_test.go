@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestQueryInt(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 10, false},
+		{"1", 1, false},
+		{"100", 100, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"101", 0, true},
+		{"ten", 0, true},
+		{"2.5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := queryInt(url.Values{"limit": {tt.value}}, "limit", 10, 1, 100)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("limit=%q: got %d, %v, want %d and an error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestQueryFloat(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"", 45.8, false},
+		{"45.81", 45.81, false},
+		{"-90", -90, false},
+		{"90.5", 0, true},
+		{"north", 0, true},
+		{"NaN", 0, true},
+		{"nan", 0, true},
+		{"Inf", 0, true},
+		{"-Inf", 0, true},
+		{"1e400", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := queryFloat(url.Values{"lat": {tt.value}}, "lat", 45.8, -90, 90)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("lat=%q: got %v, %v, want %v and an error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBoundingBoxSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"45.55,15.55,46.05,16.35", false},
+		{" 45.55, 15.55, 46.05, 16.35 ", false},
+		{"45.55,15.55,46.05", true},
+		{"46.05,15.55,45.55,16.35", true},
+		{"45.55,15.55,45.55,16.35", true},
+		{"45.55,north,46.05,16.35", true},
+		{"NaN,15.55,46.05,16.35", true},
+		{"45.55,15.55,46.05,Inf", true},
+	}
+	for _, tt := range tests {
+		var b boundingBox
+		if err := b.Set(tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("%q: error %v, want an error %v", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+// the endpoints' invalid parameters are rejected with a 400 and the usual error body
+func TestInvalidParametersRejected(t *testing.T) {
+	tests := []struct {
+		handler http.HandlerFunc
+		target  string
+	}{
+		{densityHandler, "/density?cellSize=0"},
+		{densityHandler, "/density?cellSize=NaN"},
+		{densityHandler, "/density?cellSize=big"},
+		{arrivalsHandler, "/stop/100_1/arrivals?limit=0"},
+		{arrivalsHandler, "/stop/100_1/arrivals?limit=-1"},
+		{nearestStopsHandler, "/nearest-stops?lat=NaN&lon=15.97"},
+		{nearestStopsHandler, "/nearest-stops?lat=45.8&lon=15.97&limit=-1"},
+		{historyHandler, "/history?from=yesterday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			tt.handler(recorder, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if recorder.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+
+			var response struct {
+				Error struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("the error isn't JSON: %v\n%s", err, recorder.Body)
+			}
+			if response.Error.Code != "bad_request" || response.Error.Message == "" {
+				t.Errorf("error %+v, want bad_request with a message", response.Error)
+			}
+		})
+	}
+}
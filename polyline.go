@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// encodePolyline encodes the points with Google's Encoded Polyline Algorithm at 5 decimal places,
+// https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+func encodePolyline(points []Point) string {
+	var sb strings.Builder
+	var prevLat, prevLon int64
+	for _, p := range points {
+		lat := int64(math.Round(p.Lat * 1e5))
+		lon := int64(math.Round(p.Lon * 1e5))
+		encodePolylineValue(&sb, lat-prevLat)
+		encodePolylineValue(&sb, lon-prevLon)
+		prevLat, prevLon = lat, lon
+	}
+	return sb.String()
+}
+
+func encodePolylineValue(sb *strings.Builder, value int64) {
+	shifted := value << 1
+	if value < 0 {
+		shifted = ^shifted
+	}
+	for shifted >= 0x20 {
+		sb.WriteByte(byte(0x20|(shifted&0x1f)) + 63)
+		shifted >>= 5
+	}
+	sb.WriteByte(byte(shifted) + 63)
+}
+
+// wantsPolyline tells whether the request asked for ?format=polyline, the default is arrays of coordinates
+func wantsPolyline(r *http.Request) (bool, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "array":
+		return false, nil
+	case "polyline":
+		return true, nil
+	default:
+		return false, fmt.Errorf("format must be array or polyline, got %q", format)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// decodePolyline is the client's side of encodePolyline
+func decodePolyline(t testing.TB, encoded string) []Point {
+	t.Helper()
+	decodeValue := func() int64 {
+		var result, shift int64
+		for {
+			if encoded == "" {
+				t.Fatalf("polyline ends in the middle of a value")
+			}
+			chunk := int64(encoded[0]) - 63
+			encoded = encoded[1:]
+			result |= (chunk & 0x1f) << shift
+			shift += 5
+			if chunk < 0x20 {
+				break
+			}
+		}
+		if result&1 != 0 {
+			return ^(result >> 1)
+		}
+		return result >> 1
+	}
+
+	points := []Point{}
+	var lat, lon int64
+	for encoded != "" {
+		lat += decodeValue()
+		lon += decodeValue()
+		points = append(points, Point{Lat: float64(lat) / 1e5, Lon: float64(lon) / 1e5})
+	}
+	return points
+}
+
+func TestEncodePolyline(t *testing.T) {
+	// the examples from https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+	tests := []struct {
+		name   string
+		points []Point
+		want   string
+	}{
+		{"empty", nil, ""},
+		{"Google's example", []Point{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}, "_p~iF~ps|U_ulLnnqC_mqNvxq`@"},
+		{"Google's single value", []Point{{0, -179.9832104}}, "?`~oia@"},
+		{"rounded to 5 decimals", []Point{{38.500004, -120.199996}}, "_p~iF~ps|U"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodePolyline(tt.points); got != tt.want {
+				t.Errorf("encoded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolylineRoundTrip(t *testing.T) {
+	trail := []Point{jelacicSquare, mainStation, cathedral, {Lat: 45.77813, Lon: 15.95634}, {Lat: -33.86785, Lon: 151.20732}}
+	decoded := decodePolyline(t, encodePolyline(trail))
+	if len(decoded) != len(trail) {
+		t.Fatalf("decoded %d points, want %d", len(decoded), len(trail))
+	}
+	for i := range trail {
+		if math.Abs(decoded[i].Lat-trail[i].Lat) > 5e-6 || math.Abs(decoded[i].Lon-trail[i].Lon) > 5e-6 {
+			t.Errorf("point %d decoded as %v, want %v", i, decoded[i], trail[i])
+		}
+	}
+}
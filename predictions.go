@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+var servePredicted = flag.Bool("predicted-vehicles", false, "also serve trips the feed has predictions for but no position yet, without coordinates")
+
+// how many upcoming stops are inlined in each vehicle
+const maxPredictedStops = 3
+
+type StopPrediction struct {
+	StopID   StopID `json:"stop_id"`
+	StopName string `json:"stop_name,omitempty"`
+	Time     int64  `json:"time"` // predicted arrival, unix epoch
+	TimeISO  string `json:"time_iso"`
+	// positive when running late, missing when the feed only has the absolute time
+	DelaySeconds *int32 `json:"delay_seconds,omitempty"`
+}
+
+// PredictedVehicle is a trip with a trip update but no vehicle position, usually one about to start
+type PredictedVehicle struct {
+	TripID       TripID           `json:"trip_id"`
+	RouteID      RouteID          `json:"route_id"`
+	Headsign     string           `json:"headsign"`
+	DelaySeconds *int32           `json:"delay_seconds,omitempty"`
+	NextStops    []StopPrediction `json:"next_stops"`
+	// always true, so clients mixing these with vehicles can tell them apart
+	Predicted bool `json:"predicted"`
+}
+
+// tripPrediction is what a trip update says about its trip
+type tripPrediction struct {
+	routeID   RouteID
+	delay     *int32
+	nextStops []StopPrediction
+}
+
+func getStopName(stopID StopID) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return schedule.Stops[stopID].Name
+}
+
+// getTripPredictions reads the trip updates of the feed keyed by trip, with the next few stops still ahead of now
+func getTripPredictions(feed *gtfs.FeedMessage, now time.Time) map[TripID]tripPrediction {
+	predictions := map[TripID]tripPrediction{}
+	for _, entity := range feed.Entity {
+		tripUpdate := entity.GetTripUpdate()
+		if tripUpdate == nil {
+			continue
+		}
+
+		tripID := TripID(tripUpdate.GetTrip().GetTripId())
+		prediction := tripPrediction{routeID: RouteID(tripUpdate.GetTrip().GetRouteId())}
+		if delay, ok := getTripDelay(tripUpdate, now); ok {
+			prediction.delay = &delay
+		}
+
+		for _, update := range tripUpdate.GetStopTimeUpdate() {
+			if len(prediction.nextStops) == maxPredictedStops {
+				break
+			}
+			predicted, ok := predictedTime(tripUpdate, update)
+			if !ok || predicted < now.Unix() {
+				continue
+			}
+
+			stopID := StopID(update.GetStopId())
+			if stopID == "" {
+				if scheduled, exists := getScheduledStopTime(tripID, update); exists {
+					stopID = scheduled.StopID
+				}
+			}
+			stop := StopPrediction{
+				StopID:   stopID,
+				StopName: getStopName(stopID),
+				Time:     predicted,
+				TimeISO:  formatZagrebTime(predicted),
+			}
+			if event := getStopTimeEvent(update); event.Delay != nil {
+				delay := event.GetDelay()
+				stop.DelaySeconds = &delay
+			}
+			prediction.nextStops = append(prediction.nextStops, stop)
+		}
+
+		predictions[tripID] = prediction
+	}
+	return predictions
+}
+
+// getPredictedVehicles returns the predicted trips none of the vehicles is running, ordered by route and trip
+func getPredictedVehicles(routes map[RouteID]Vehicles, predictions map[TripID]tripPrediction) []PredictedVehicle {
+	tracked := map[TripID]bool{}
+	for _, vehicles := range routes {
+		for _, v := range vehicles {
+			tracked[v.tripID] = true
+		}
+	}
+
+	predicted := []PredictedVehicle{}
+	for tripID, prediction := range predictions {
+		// nothing left to predict once the trip is over
+		if tracked[tripID] || len(prediction.nextStops) == 0 || !isRouteServed(prediction.routeID) {
+			continue
+		}
+		trip, _ := getTrip(prediction.routeID, tripID)
+		predicted = append(predicted, PredictedVehicle{
+			TripID:       tripID,
+			RouteID:      prediction.routeID,
+			Headsign:     getHeadsign(prediction.routeID, trip),
+			DelaySeconds: prediction.delay,
+			NextStops:    prediction.nextStops,
+			Predicted:    true,
+		})
+	}
+
+	slices.SortFunc(predicted, func(a, b PredictedVehicle) int {
+		if c := compareRouteIDs(a.RouteID, b.RouteID); c != 0 {
+			return c
+		}
+		return compareNatural(string(a.TripID), string(b.TripID))
+	})
+	return predicted
+}
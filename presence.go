@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+var logRemovals = flag.Bool("log-removals", false, "log the IDs of vehicles which dropped out of the feed")
+
+// how many consecutive feeds a vehicle can be missing from before it's removed,
+// vehicles regularly skip a single feed and shouldn't flap off the map and back
+const vanishGraceTicks = 1
+
+// consecutive feeds each vehicle has been missing from, only touched from the goroutine polling the feed
+var missingTicks = map[string]int{}
+
+// vehicles which were missing for longer than the grace period and got removed
+var departedVehicles atomic.Int64
+
+// carryOverVanished copies the vehicles which are briefly missing from the feed over from the previous snapshot,
+// and returns the IDs of the ones missing for too long, which are gone for good
+func carryOverVanished(old *Snapshot, routes map[RouteID]Vehicles) []string {
+	present := map[string]bool{}
+	for _, vehicles := range routes {
+		for _, v := range vehicles {
+			present[v.ID] = true
+			delete(missingTicks, v.ID)
+		}
+	}
+
+	// a feed without any vehicles is handled by the empty feed grace period instead
+	if len(present) == 0 {
+		return nil
+	}
+
+	removed := []string{}
+	carried := map[RouteID]bool{}
+	for routeID, vehicles := range old.Routes {
+		for _, v := range vehicles {
+			if present[v.ID] {
+				continue
+			}
+			missingTicks[v.ID]++
+			if missingTicks[v.ID] > vanishGraceTicks {
+				delete(missingTicks, v.ID)
+				removed = append(removed, v.ID)
+				continue
+			}
+			routes[routeID] = append(routes[routeID], v)
+			carried[routeID] = true
+		}
+	}
+
+	for routeID := range carried {
+		slices.SortFunc(routes[routeID], func(a, b Vehicle) int { return strings.Compare(a.ID, b.ID) })
+	}
+	slices.Sort(removed)
+
+	if *logRemovals && len(removed) > 0 {
+		log.Printf("%d vehicles left the feed: %s\n", len(removed), strings.Join(removed, ", "))
+	}
+	return removed
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCarryOverVanished(t *testing.T) {
+	t.Cleanup(func() { clear(missingTicks) })
+
+	previous := newSnapshot(map[RouteID]Vehicles{"6": {{ID: "a"}, {ID: "b"}}}, 1)
+	tick := func(ids ...string) (map[RouteID]Vehicles, []string) {
+		routes := map[RouteID]Vehicles{}
+		for _, id := range ids {
+			routes["6"] = append(routes["6"], Vehicle{ID: id})
+		}
+		departed := carryOverVanished(previous, routes)
+		previous = newSnapshot(routes, previous.Timestamp+1)
+		return routes, departed
+	}
+
+	// missing from one feed, it's kept
+	routes, departed := tick("a")
+	if len(departed) != 0 || len(routes["6"]) != 2 || routes["6"][1].ID != "b" {
+		t.Fatalf("first tick without b: routes %v, departed %v", routes, departed)
+	}
+
+	// missing for longer than the grace period, it's gone
+	routes, departed = tick("a")
+	if !slices.Equal(departed, []string{"b"}) || len(routes["6"]) != 1 {
+		t.Fatalf("second tick without b: routes %v, departed %v", routes, departed)
+	}
+
+	// an empty feed is left to the empty feed grace period
+	if _, departed = tick(); departed != nil {
+		t.Errorf("empty feed: departed %v", departed)
+	}
+}
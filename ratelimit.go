@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var rateLimit = flag.Float64("rate-limit", 2, "requests per second each client IP may make to the data endpoints, 0 for no limit")
+var rateBurst = flag.Int("rate-burst", 10, "requests a client IP may make in a burst above the rate limit")
+var trustProxy = flag.Bool("trust-proxy", false, "identify clients by X-Forwarded-For, only enable behind a trusted reverse proxy")
+var proxyHops = flag.Int("proxy-hops", 1, "trusted reverse proxies in front of the server with -trust-proxy, the client is the address the outermost one appended to X-Forwarded-For")
+
+// clients which haven't made a request for this long get their limiter dropped
+const idleLimiterTimeout = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter is a token bucket per client IP
+type ipRateLimiter struct {
+	mu          sync.Mutex
+	clients     map[string]*clientLimiter
+	lastCleanup time.Time
+}
+
+var dataRateLimiter = &ipRateLimiter{clients: map[string]*clientLimiter{}}
+
+func (l *ipRateLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > idleLimiterTimeout {
+		for clientIP, client := range l.clients {
+			if now.Sub(client.lastSeen) > idleLimiterTimeout {
+				delete(l.clients, clientIP)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	client, exists := l.clients[ip]
+	if !exists {
+		client = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(*rateLimit), *rateBurst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+	return client.limiter
+}
+
+func clientIP(r *http.Request) string {
+	if *trustProxy {
+		// each proxy appends the address it got the request from, anything to the left of what our proxies
+		// appended came from the client and can be made up to dodge the limit
+		var forwarded []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, address := range strings.Split(header, ",") {
+				if address = strings.TrimSpace(address); address != "" {
+					forwarded = append(forwarded, address)
+				}
+			}
+		}
+		if len(forwarded) > 0 {
+			return forwarded[max(0, len(forwarded)-max(1, *proxyHops))]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitRate rejects requests over the client's rate with a 429
+func limitRate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *rateLimit <= 0 {
+			next(w, r)
+			return
+		}
+
+		reservation := dataRateLimiter.get(clientIP(r)).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		hops       int
+		forwarded  []string
+		want       string
+	}{
+		{name: "no proxy", want: "192.0.2.1"},
+		{name: "untrusted header", forwarded: []string{"203.0.113.7"}, want: "192.0.2.1"},
+		{name: "single proxy", trustProxy: true, hops: 1, forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "spoofed entry", trustProxy: true, hops: 1, forwarded: []string{"10.9.9.9, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "two proxies", trustProxy: true, hops: 2, forwarded: []string{"10.9.9.9, 203.0.113.7, 198.51.100.2"}, want: "203.0.113.7"},
+		{name: "repeated headers", trustProxy: true, hops: 1, forwarded: []string{"10.9.9.9", "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "fewer entries than hops", trustProxy: true, hops: 3, forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "proxy without header", trustProxy: true, hops: 1, want: "192.0.2.1"},
+	}
+
+	defer func(trust bool, hops int) { *trustProxy, *proxyHops = trust, hops }(*trustProxy, *proxyHops)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*trustProxy, *proxyHops = test.trustProxy, test.hops
+			r := httptest.NewRequest("GET", "/vehicles", nil)
+			r.RemoteAddr = "192.0.2.1:54321"
+			for _, header := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if got := clientIP(r); got != test.want {
+				t.Errorf("clientIP() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
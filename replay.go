@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var recordDir = flag.String("record", "", "directory to record every fetched realtime feed into")
+var recordKeep = flag.Int("record-keep", 10000, "number of most recent recorded feeds to keep")
+var replayDir = flag.String("replay", "", "directory of recorded feeds to serve instead of fetching the live feed")
+var replaySpeed = flag.Float64("replay-speed", 1, "how much faster than real time the recording is replayed")
+
+var errReplayFinished = errors.New("replay finished")
+
+// feedSource provides raw GTFS Realtime feeds
+type feedSource interface {
+	fetch() ([]byte, error)
+	// wait blocks until the next feed is due
+	wait()
+}
+
+type liveFeed struct {
+	url string
+	// nil unless -record is set
+	recorder *feedRecorder
+}
+
+func (f liveFeed) fetch() ([]byte, error) {
+	data, err := fetchGTFSRealTime(f.url)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.recorder != nil {
+		if err := f.recorder.record(data, time.Now()); err != nil {
+			log.Println("Could not record the feed: ", err)
+		}
+	}
+	return data, nil
+}
+
+func (f liveFeed) wait() {
+	time.Sleep(time.Duration(getTunables().PollInterval))
+}
+
+// recordings are named by the unix millisecond they were fetched at, so they sort chronologically
+const recordingExtension = ".pb"
+
+func recordingName(t time.Time) string {
+	return fmt.Sprintf("%013d%s", t.UnixMilli(), recordingExtension)
+}
+
+func listRecordings(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), recordingExtension) {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// feedRecorder writes feeds into a directory, keeping only the most recent ones
+type feedRecorder struct {
+	dir  string
+	keep int
+	// the recordings in dir, oldest first, listed once on the first feed and kept up to date after that
+	recordings []string
+}
+
+func newFeedRecorder(dir string, keep int) *feedRecorder {
+	return &feedRecorder{dir: dir, keep: keep}
+}
+
+func (r *feedRecorder) record(data []byte, fetchedAt time.Time) error {
+	if r.recordings == nil {
+		if err := os.MkdirAll(r.dir, 0o755); err != nil {
+			return err
+		}
+		recordings, err := listRecordings(r.dir)
+		if err != nil {
+			return err
+		}
+		r.recordings = recordings
+	}
+
+	name := recordingName(fetchedAt)
+	if err := os.WriteFile(filepath.Join(r.dir, name), data, 0o644); err != nil {
+		return err
+	}
+	// two feeds fetched within the same millisecond share the file
+	if len(r.recordings) == 0 || r.recordings[len(r.recordings)-1] != name {
+		r.recordings = append(r.recordings, name)
+	}
+
+	for len(r.recordings) > r.keep {
+		if err := os.Remove(filepath.Join(r.dir, r.recordings[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		r.recordings = r.recordings[1:]
+	}
+	return nil
+}
+
+// replayFeed serves recorded feeds spaced out the same way they were fetched, scaled by speed
+type replayFeed struct {
+	dir        string
+	recordings []string
+	next       int
+	speed      float64
+}
+
+func newReplayFeed(dir string, speed float64) (*replayFeed, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("Replay speed must be positive, got %v", speed)
+	}
+
+	recordings, err := listRecordings(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(recordings) == 0 {
+		return nil, fmt.Errorf("No recordings found in %s", dir)
+	}
+
+	log.Printf("Replaying %d recorded feeds from %s at %vx speed\n", len(recordings), dir, speed)
+	return &replayFeed{dir: dir, recordings: recordings, speed: speed}, nil
+}
+
+func recordedAt(name string) time.Time {
+	millis, _ := strconv.ParseInt(strings.TrimSuffix(name, recordingExtension), 10, 64)
+	return time.UnixMilli(millis)
+}
+
+func (f *replayFeed) fetch() ([]byte, error) {
+	if f.next >= len(f.recordings) {
+		return nil, errReplayFinished
+	}
+	name := f.recordings[f.next]
+	f.next++
+	return os.ReadFile(filepath.Join(f.dir, name))
+}
+
+func (f *replayFeed) wait() {
+	if f.next == 0 || f.next >= len(f.recordings) {
+		return
+	}
+	gap := recordedAt(f.recordings[f.next]).Sub(recordedAt(f.recordings[f.next-1]))
+	time.Sleep(time.Duration(float64(gap) / f.speed))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFeedRecorderKeepsTheLatest(t *testing.T) {
+	dir := t.TempDir()
+	start := time.UnixMilli(1_700_000_000_000)
+
+	// left over from a previous run
+	for i := range 2 {
+		if err := os.WriteFile(filepath.Join(dir, recordingName(start.Add(time.Duration(i)*time.Second))), []byte("old"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := newFeedRecorder(dir, 3)
+	for i := 2; i < 5; i++ {
+		if err := recorder.record([]byte("new"), start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	onDisk, err := listRecordings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		recordingName(start.Add(2 * time.Second)),
+		recordingName(start.Add(3 * time.Second)),
+		recordingName(start.Add(4 * time.Second)),
+	}
+	if !slices.Equal(onDisk, want) {
+		t.Errorf("recordings on disk %v, want %v", onDisk, want)
+	}
+	if !slices.Equal(recorder.recordings, onDisk) {
+		t.Errorf("tracked recordings %v, on disk %v", recorder.recordings, onDisk)
+	}
+
+	replay, err := newReplayFeed(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range want {
+		if data, err := replay.fetch(); err != nil || string(data) != "new" {
+			t.Fatalf("replayed %q, %v", data, err)
+		}
+	}
+	if _, err := replay.fetch(); err != errReplayFinished {
+		t.Errorf("fetch after the last recording: %v, want %v", err, errReplayFinished)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// GTFS route_type values
+const (
+	routeTypeTram = 0
+	routeTypeBus  = 3
+)
+
+type Route struct {
+	ID        RouteID
+	ShortName string
+	LongName  string
+	Type      int    // -1 when unknown
+	Color     string // hex without the leading #, empty when unknown
+	// readable on top of Color, derived from its luminance when routes.txt doesn't say
+	TextColor string
+}
+
+func getRoute(routeID RouteID) (Route, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	route, exists := schedule.Routes[routeID]
+	return route, exists
+}
+
+func parseRoutes(data []byte) (map[RouteID]Route, error) {
+	table, err := parseCSV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[RouteID]Route, len(table.rows))
+	for _, row := range table.rows {
+		routeID := RouteID(table.get(row, "route_id"))
+		routeType, err := strconv.Atoi(table.get(row, "route_type"))
+		if err != nil {
+			routeType = -1
+		}
+		result[routeID] = Route{
+			ID:        routeID,
+			ShortName: table.get(row, "route_short_name"),
+			LongName:  table.get(row, "route_long_name"),
+			Type:      routeType,
+			Color:     table.get(row, "route_color"),
+			TextColor: table.get(row, "route_text_color"),
+		}
+		if route := result[routeID]; route.TextColor == "" {
+			route.TextColor = contrastColor(route.Color)
+			result[routeID] = route
+		}
+	}
+	return result, nil
+}
+
+// contrastColor returns black or white, whichever is more readable on the given hex color,
+// empty if the color is unknown or invalid
+func contrastColor(hex string) string {
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return ""
+	}
+
+	// relative luminance as WCAG defines it
+	linear := func(channel uint64) float64 {
+		c := float64(channel) / 255
+		if c <= 0.03928 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	luminance := 0.2126*linear(rgb>>16&0xff) + 0.7152*linear(rgb>>8&0xff) + 0.0722*linear(rgb&0xff)
+
+	// where the contrast ratio against black and against white is the same
+	if luminance > 0.179 {
+		return "000000"
+	}
+	return "FFFFFF"
+}
+
+// compareNatural orders digit runs by their numeric value, so "2" < "13" < "268" and "N2" < "N10"
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits == "" || bDigits == "" {
+			if a[0] != b[0] {
+				return strings.Compare(a[:1], b[:1])
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		// leading zeros don't change the value
+		aNumber, bNumber := strings.TrimLeft(aDigits, "0"), strings.TrimLeft(bDigits, "0")
+		if len(aNumber) != len(bNumber) {
+			return len(aNumber) - len(bNumber)
+		}
+		if c := strings.Compare(aNumber, bNumber); c != 0 {
+			return c
+		}
+		a, b = a[len(aDigits):], b[len(bDigits):]
+	}
+	return len(a) - len(b)
+}
+
+// compareRouteIDs is compareNatural for sorting route listings
+func compareRouteIDs(a, b RouteID) int {
+	return compareNatural(string(a), string(b))
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+type ActiveRoute struct {
+	ID        RouteID `json:"id"`
+	ShortName string  `json:"short_name"`
+	LongName  string  `json:"long_name"`
+	Type      int     `json:"type"`
+	Color     string  `json:"color"`
+	TextColor string  `json:"text_color"`
+	Vehicles  int     `json:"vehicles"`
+}
+
+// getActiveRoutes returns the routes with at least one vehicle in the snapshot, falling back to the route ID
+// as the short name for routes missing from the schedule
+func getActiveRoutes(snapshot *Snapshot) []ActiveRoute {
+	active := []ActiveRoute{}
+	for routeID, vehicles := range snapshot.Routes {
+		if len(vehicles) == 0 {
+			continue
+		}
+
+		route, exists := getRoute(routeID)
+		if !exists {
+			route = Route{ShortName: string(routeID), Type: -1}
+		}
+		active = append(active, ActiveRoute{
+			ID:        routeID,
+			ShortName: route.ShortName,
+			LongName:  route.LongName,
+			Type:      route.Type,
+			Color:     route.Color,
+			TextColor: route.TextColor,
+			Vehicles:  len(vehicles),
+		})
+	}
+
+	slices.SortFunc(active, func(a, b ActiveRoute) int {
+		if c := compareNatural(a.ShortName, b.ShortName); c != 0 {
+			return c
+		}
+		return compareRouteIDs(a.ID, b.ID)
+	})
+	return active
+}
+
+func activeRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Routes []ActiveRoute `json:"routes"`
+	}{
+		Routes: getActiveRoutes(allVehicles.Load().(*Snapshot)),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
+
+type RouteInfo struct {
+	ID        RouteID `json:"id"`
+	ShortName string  `json:"short_name"`
+	LongName  string  `json:"long_name"`
+	Type      int     `json:"type"`
+	Color     string  `json:"color"`
+	TextColor string  `json:"text_color"`
+}
+
+// getRouteInfos lists every route in the schedule, whether it's running or not
+func getRouteInfos() []RouteInfo {
+	mu.RLock()
+	routes := make([]RouteInfo, 0, len(schedule.Routes))
+	for _, route := range schedule.Routes {
+		routes = append(routes, RouteInfo{
+			ID:        route.ID,
+			ShortName: route.ShortName,
+			LongName:  route.LongName,
+			Type:      route.Type,
+			Color:     route.Color,
+			TextColor: route.TextColor,
+		})
+	}
+	mu.RUnlock()
+
+	slices.SortFunc(routes, func(a, b RouteInfo) int {
+		if c := compareNatural(a.ShortName, b.ShortName); c != 0 {
+			return c
+		}
+		return compareRouteIDs(a.ID, b.ID)
+	})
+	return routes
+}
+
+func routesHandler(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		ScheduleVersion string      `json:"schedule_version"`
+		Routes          []RouteInfo `json:"routes"`
+	}{
+		ScheduleVersion: getScheduleVersion(),
+		Routes:          getRouteInfos(),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
+
+// routeVehiclesHandler is /vehicles?route= for a single route, with the route's details alongside,
+// and a 404 rather than an empty listing when the route has no vehicles running
+func routeVehiclesHandler(w http.ResponseWriter, r *http.Request) {
+	routeID := RouteID(r.PathValue("id"))
+	vehicles := allVehicles.Load().(*Snapshot).Routes[routeID]
+	if len(vehicles) == 0 {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Route %q has no vehicles running", routeID))
+		return
+	}
+
+	route, exists := getRoute(routeID)
+	if !exists {
+		route = Route{ShortName: string(routeID), Type: -1}
+	}
+	response := struct {
+		Route    RouteInfo `json:"route"`
+		Vehicles Vehicles  `json:"vehicles"`
+		Units    Units     `json:"units"`
+	}{
+		Units: apiUnits(),
+		Route: RouteInfo{
+			ID:        routeID,
+			ShortName: route.ShortName,
+			LongName:  route.LongName,
+			Type:      route.Type,
+			Color:     route.Color,
+			TextColor: route.TextColor,
+		},
+		Vehicles: vehicles,
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
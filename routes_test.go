@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCompareNatural(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int // only the sign matters
+	}{
+		{"2", "13", -1},
+		{"13", "2", 1},
+		{"6", "6", 0},
+		{"17", "101", -1},
+		{"268", "109", 1},
+		{"007", "7", 0},
+		{"N2", "N10", -1},
+		{"1A", "1B", -1},
+		{"1", "1A", -1},
+		{"", "1", -1},
+	}
+	for _, tt := range tests {
+		got := compareNatural(tt.a, tt.b)
+		if (got < 0) != (tt.want < 0) || (got > 0) != (tt.want > 0) {
+			t.Errorf("compareNatural(%q, %q) = %d, want the sign of %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	// tram, night tram, bus and night bus lines
+	routes := []string{"268", "31", "N10", "6", "101", "2", "13", "N2", "1", "109", "17", "34"}
+	slices.SortFunc(routes, compareNatural)
+	want := []string{"1", "2", "6", "13", "17", "31", "34", "101", "109", "268", "N2", "N10"}
+	if !slices.Equal(routes, want) {
+		t.Errorf("sorted %v, want %v", routes, want)
+	}
+}
+
+func TestRouteCountsMarshalJSONNaturalOrder(t *testing.T) {
+	data, err := json.Marshal(routeCounts{"13": 4, "2": 1, "268": 2, "6": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"2":1,"6":7,"13":4,"268":2}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestRouteVehiclesHandler(t *testing.T) {
+	setSchedule(t, &Schedule{Routes: map[RouteID]Route{
+		"6":  {ID: "6", ShortName: "6", LongName: "Črnomerec - Sopot", Type: routeTypeTram, Color: "1264AB", TextColor: "FFFFFF"},
+		"14": {ID: "14", ShortName: "14", LongName: "Mihaljevac - Zapruđe", Type: routeTypeTram, Color: "1264AB", TextColor: "FFFFFF"},
+	}})
+	previous := allVehicles.Load()
+	allVehicles.Store(newSnapshot(map[RouteID]Vehicles{
+		"6":   {{ID: "601"}, {ID: "602"}},
+		"268": {{ID: "26801"}},
+	}, 1_700_000_000))
+	t.Cleanup(func() { allVehicles.Store(previous) })
+
+	get := func(routeID string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/routes/"+routeID+"/vehicles", nil)
+		request.SetPathValue("id", routeID)
+		recorder := httptest.NewRecorder()
+		routeVehiclesHandler(recorder, request)
+		return recorder
+	}
+	type envelope struct {
+		Route    RouteInfo `json:"route"`
+		Vehicles Vehicles  `json:"vehicles"`
+	}
+	decode := func(recorder *httptest.ResponseRecorder) envelope {
+		t.Helper()
+		var response envelope
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%v\n%s", err, recorder.Body)
+		}
+		return response
+	}
+
+	recorder := get("6")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("route 6: status %d", recorder.Code)
+	}
+	want := RouteInfo{ID: "6", ShortName: "6", LongName: "Črnomerec - Sopot", Type: routeTypeTram, Color: "1264AB", TextColor: "FFFFFF"}
+	if response := decode(recorder); response.Route != want || len(response.Vehicles) != 2 {
+		t.Errorf("route 6: %+v with %d vehicles, want %+v with 2", response.Route, len(response.Vehicles), want)
+	}
+
+	// vehicles on a route the schedule doesn't know are still listed, under the route ID
+	recorder = get("268")
+	if response := decode(recorder); recorder.Code != http.StatusOK || response.Route.ShortName != "268" || response.Route.Type != -1 || len(response.Vehicles) != 1 {
+		t.Errorf("route 268 missing from the schedule: status %d, %+v", recorder.Code, response)
+	}
+
+	// a route in the schedule without vehicles running and a route which doesn't exist at all
+	for _, routeID := range []string{"14", "999"} {
+		if recorder := get(routeID); recorder.Code != http.StatusNotFound {
+			t.Errorf("route %s: status %d, want %d", routeID, recorder.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"sync"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+var includeRoutes = flag.String("include-routes", "", "comma separated route IDs or short names, only these are ever served, e.g. for a trams only map")
+var excludeRoutes = flag.String("exclude-routes", "", "comma separated route IDs or short names which are never served, e.g. the night buses, ignored with -include-routes")
+
+// parseRouteList splits a list of route IDs or short names, nil when it's empty
+func parseRouteList(value string) map[string]bool {
+	var routes map[string]bool
+	for _, route := range strings.Split(value, ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			if routes == nil {
+				routes = map[string]bool{}
+			}
+			routes[route] = true
+		}
+	}
+	return routes
+}
+
+var servedRoutes = sync.OnceValues(func() (include, exclude map[string]bool) {
+	return parseRouteList(*includeRoutes), parseRouteList(*excludeRoutes)
+})
+
+// isRouteServed tells whether the deployment serves the route at all, by its ID or its short name
+func isRouteServed(routeID RouteID) bool {
+	include, exclude := servedRoutes()
+	if include == nil && exclude == nil {
+		return true
+	}
+
+	names := []string{string(routeID)}
+	if route, exists := getRoute(routeID); exists {
+		names = append(names, route.ShortName)
+	}
+	listed := func(routes map[string]bool) bool {
+		for _, name := range names {
+			if routes[name] {
+				return true
+			}
+		}
+		return false
+	}
+
+	if include != nil {
+		return listed(include)
+	}
+	return !listed(exclude)
+}
+
+// dropUnservedRoutes leaves out the vehicles on routes the deployment doesn't serve,
+// before any work is done on them so they never reach a snapshot
+func dropUnservedRoutes(vehicles []*gtfs.VehiclePosition) []*gtfs.VehiclePosition {
+	served := make([]*gtfs.VehiclePosition, 0, len(vehicles))
+	for _, v := range vehicles {
+		if isRouteServed(RouteID(v.GetTrip().GetRouteId())) {
+			served = append(served, v)
+		}
+	}
+	return served
+}
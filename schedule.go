@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// files from the schedule zip we need, a download missing any of them is rejected
+var scheduleFiles = []string{"trips.txt", "routes.txt", "shapes.txt", "stops.txt", "stop_times.txt"}
+
+// files from the schedule zip which are used when present
+var optionalScheduleFiles = []string{"calendar.txt", "calendar_dates.txt", "frequencies.txt"}
+
+// Schedule is the parsed static GTFS data, it's replaced as a whole when a new one is published
+type Schedule struct {
+	Trips     RoutesToTrips
+	Routes    map[RouteID]Route
+	Shapes    map[ShapeID]Shape
+	Stops     map[StopID]Stop
+	StopTimes map[TripID][]StopTime
+	// Content-Disposition of the download, it contains the schedule version
+	Filename string
+	// Last-Modified of the download, for servers without a versioned filename
+	LastModified string
+	// the service day Trips were filtered for, empty when they weren't
+	ServiceDay string
+}
+
+var schedule = &Schedule{
+	Trips:     RoutesToTrips{},
+	Routes:    map[RouteID]Route{},
+	Shapes:    map[ShapeID]Shape{},
+	Stops:     map[StopID]Stop{},
+	StopTimes: map[TripID][]StopTime{},
+}
+
+// closed and replaced every time a new schedule is swapped in
+var scheduleUpdated = make(chan struct{})
+
+// scheduleUpdatedSignal returns a channel which gets closed once the next schedule is loaded
+func scheduleUpdatedSignal() <-chan struct{} {
+	mu.RLock()
+	defer mu.RUnlock()
+	return scheduleUpdated
+}
+
+var scheduleVersionPattern = regexp.MustCompile(`filename=zet-gtfs-scheduled-(.+)\.zip`)
+
+// getScheduleVersion returns the edition of the loaded schedule, e.g. 000-00369,
+// or the whole Content-Disposition if it isn't ZET's usual one
+func getScheduleVersion() string {
+	filename := getScheduleFilename()
+	if match := scheduleVersionPattern.FindStringSubmatch(filename); match != nil {
+		return match[1]
+	}
+	return filename
+}
+
+func getScheduleLastModified() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return schedule.LastModified
+}
+
+func getScheduleFilename() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return schedule.Filename
+}
+
+// isScheduleAvailable reports whether a schedule has been loaded at all, without it vehicles have no headsigns
+func isScheduleAvailable() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(schedule.Trips) > 0
+}
+
+func fetchScheduleFiles() (map[string][]byte, http.Header, error) {
+	resp, err := httpClient.Get(tripsDataURL)
+	if err != nil {
+		log.Println("Could not fetch trips data: ", err)
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("Unexpected response code: %d", resp.StatusCode)
+	}
+
+	// the archive is several megabytes, so spool it to disk instead of holding it in memory,
+	// only the members we need get decompressed
+	archive, err := os.CreateTemp("", "zet-gtfs-scheduled-*.zip")
+	if err != nil {
+		log.Println("Could not create a temporary file for trips data: ", err)
+		return nil, nil, err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	size, err := io.Copy(archive, resp.Body)
+	if err != nil {
+		log.Println("Could not read trips data response: ", err)
+		return nil, nil, err
+	}
+	if resp.ContentLength >= 0 && size != resp.ContentLength {
+		err := fmt.Errorf("Trips data download truncated, got %d out of %d bytes", size, resp.ContentLength)
+		log.Println(err)
+		return nil, nil, err
+	}
+
+	zipReader, err := zip.NewReader(archive, size)
+	if err != nil {
+		log.Println("Could not create a zip reader for trips data, the download is probably corrupt: ", err)
+		return nil, nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, zipFile := range zipReader.File {
+		if !slices.Contains(scheduleFiles, zipFile.Name) && !slices.Contains(optionalScheduleFiles, zipFile.Name) {
+			continue
+		}
+		unzippedFileBytes, err := readZipFile(zipFile)
+		if err != nil {
+			log.Printf("Could not unzip %s: %v\n", zipFile.Name, err)
+			return nil, nil, err
+		}
+		files[zipFile.Name] = unzippedFileBytes
+	}
+
+	for _, name := range scheduleFiles {
+		if _, exists := files[name]; !exists {
+			return nil, nil, fmt.Errorf("%s not present in response", name)
+		}
+	}
+	return files, resp.Header, nil
+}
+
+// csvTable is a parsed GTFS file, rows are accessed by column name
+type csvTable struct {
+	columns map[string]int
+	rows    [][]string
+}
+
+func (t csvTable) get(row []string, column string) string {
+	if i, exists := t.columns[column]; exists && i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+func parseCSV(data []byte) (csvTable, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1 // some rows omit trailing empty fields
+
+	header, err := reader.Read()
+	if err != nil {
+		return csvTable{}, fmt.Errorf("Could not parse CSV header: %v", err)
+	}
+
+	table := csvTable{columns: map[string]int{}}
+	for i, column := range header {
+		// the first column may be prefixed with a UTF-8 BOM
+		table.columns[strings.TrimPrefix(column, "\ufeff")] = i
+	}
+
+	table.rows, err = reader.ReadAll()
+	if err != nil {
+		return csvTable{}, fmt.Errorf("Could not parse CSV: %v", err)
+	}
+	return table, nil
+}
+
+func parseTrips(data []byte) (RoutesToTrips, error) {
+	table, err := parseCSV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	trips := RoutesToTrips{}
+	for _, row := range table.rows {
+		routeID := RouteID(table.get(row, "route_id"))
+		if _, exists := trips[routeID]; !exists {
+			trips[routeID] = Trips{}
+		}
+
+		tripID := TripID(table.get(row, "trip_id"))
+		trips[routeID][tripID] = Trip{
+			Headsign:  table.get(row, "trip_headsign"),
+			ShortName: table.get(row, "trip_short_name"),
+			ServiceID: table.get(row, "service_id"),
+			Direction: table.get(row, "direction_id"),
+			ShapeID:   ShapeID(table.get(row, "shape_id")),
+
+			WheelchairAccessible: parseAccessibility(table.get(row, "wheelchair_accessible")),
+		}
+	}
+	return trips, nil
+}
+
+// scheduleParser parses one of the schedule's files into the schedule it was made for
+type scheduleParser struct {
+	filename string
+	parse    func(data []byte) error
+}
+
+// scheduleParsers parse the files that make up a schedule into staging
+func scheduleParsers(staging *Schedule) []scheduleParser {
+	return []scheduleParser{
+		{"trips.txt", func(data []byte) (err error) { staging.Trips, err = parseTrips(data); return }},
+		{"routes.txt", func(data []byte) (err error) { staging.Routes, err = parseRoutes(data); return }},
+		{"shapes.txt", func(data []byte) (err error) { staging.Shapes, err = parseShapes(data); return }},
+		{"stops.txt", func(data []byte) (err error) { staging.Stops, err = parseStops(data); return }},
+		{"stop_times.txt", func(data []byte) (err error) { staging.StopTimes, err = parseStopTimes(data); return }},
+	}
+}
+
+// parseFiles runs the parsers at the same time, the files don't depend on each other,
+// the schedule is only used once all of them are done
+func parseFiles(files map[string][]byte, parsers []scheduleParser) error {
+	errs := make([]error, len(parsers))
+	var wg sync.WaitGroup
+	for i, parser := range parsers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = parser.parse(files[parser.filename])
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("Could not parse %s: %v", parsers[i].filename, err)
+		}
+	}
+	return nil
+}
+
+// parseSchedule parses every file of the schedule, failing if any of them can't be parsed
+func parseSchedule(files map[string][]byte) (*Schedule, error) {
+	staging := &Schedule{}
+	if err := parseFiles(files, scheduleParsers(staging)); err != nil {
+		return nil, err
+	}
+
+	if data, exists := files["frequencies.txt"]; exists {
+		frequencies, err := parseFrequencies(data)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse frequencies.txt: %v", err)
+		}
+		for _, routeTrips := range staging.Trips {
+			for tripID, trip := range routeTrips {
+				if tripFrequencies, exists := frequencies[tripID]; exists {
+					trip.Frequencies = tripFrequencies
+					routeTrips[tripID] = trip
+				}
+			}
+		}
+	}
+
+	_, hasCalendar := files["calendar.txt"]
+	_, hasCalendarDates := files["calendar_dates.txt"]
+	if *filterServices && (hasCalendar || hasCalendarDates) {
+		calendar, err := parseCalendar(files["calendar.txt"], files["calendar_dates.txt"])
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse the calendar: %v", err)
+		}
+		staging.ServiceDay = getServiceDay(time.Now())
+		if staging.Trips, err = filterActiveTrips(staging.Trips, calendar, staging.ServiceDay); err != nil {
+			return nil, err
+		}
+	}
+
+	// shapes are drawn for direction 0 if any such trip uses them
+	for _, routeTrips := range staging.Trips {
+		for _, trip := range routeTrips {
+			shape, exists := staging.Shapes[trip.ShapeID]
+			if exists && (shape.Direction == "" || trip.Direction == "0") {
+				shape.Direction = trip.Direction
+				staging.Shapes[trip.ShapeID] = shape
+			}
+		}
+	}
+
+	return staging, nil
+}
+
+// loadSchedule fetches the scheduled GTFS data and swaps it in only if all of it parsed,
+// otherwise the previous schedule keeps being served
+func loadSchedule() error {
+	files, headers, err := loadCachedScheduleFiles()
+	if err != nil {
+		log.Println("Downloading the schedule, the cached files can't be used: ", err)
+		files, headers, err = fetchScheduleFiles()
+		if err != nil {
+			return err
+		}
+		if err := cacheScheduleFiles(files, headers); err != nil {
+			log.Println("Could not cache the schedule files: ", err)
+		}
+	}
+
+	staging, err := parseSchedule(files)
+	if err != nil {
+		return err
+	}
+	staging.Filename = headers.Get("Content-Disposition")
+	staging.LastModified = headers.Get("Last-Modified")
+
+	mu.Lock()
+	defer mu.Unlock()
+	schedule = staging
+	close(scheduleUpdated)
+	scheduleUpdated = make(chan struct{})
+	return nil
+}
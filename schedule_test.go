@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// syntheticSchedule is the fixture schedule grown to about ZET's size, every trip has its own shape and stop times
+func syntheticSchedule(routes, tripsPerRoute, stopsPerTrip int) map[string][]byte {
+	var trips, shapes, stopTimes strings.Builder
+	trips.WriteString("route_id,service_id,trip_id,trip_headsign,direction_id,shape_id\n")
+	shapes.WriteString("shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\n")
+	stopTimes.WriteString("trip_id,arrival_time,departure_time,stop_id,stop_sequence\n")
+
+	routeRows := []string{"route_id,route_short_name,route_long_name,route_type,route_color"}
+	stopRows := []string{"stop_id,stop_name,stop_lat,stop_lon"}
+	for stop := range stopsPerTrip * 10 {
+		stopRows = append(stopRows, fmt.Sprintf("%d_1,Stop %d,%.5f,%.5f", stop, stop, 45.75+float64(stop%100)*0.001, 15.9+float64(stop/100)*0.01))
+	}
+	for route := range routes {
+		routeRows = append(routeRows, fmt.Sprintf("%d,%d,Route %d,%d,1264AB", route, route, route, route%2*3))
+		for trip := range tripsPerRoute {
+			tripID := fmt.Sprintf("0_1_%d_%d", route, trip)
+			fmt.Fprintf(&trips, "%d,0_1,%s,Terminus %d,%d,%s\n", route, tripID, trip%2, trip%2, tripID)
+			for stop := range stopsPerTrip {
+				// a few shape points between every two stops
+				for point := range 5 {
+					fmt.Fprintf(&shapes, "%s,%.5f,%.5f,%d\n", tripID, 45.75+float64(stop)*0.001, 15.9+float64(point)*0.0002, stop*5+point+1)
+				}
+				at := 5*3600 + trip*600 + stop*90
+				fmt.Fprintf(&stopTimes, "%s,%02d:%02d:%02d,%02d:%02d:%02d,%d_1,%d\n", tripID,
+					at/3600, at/60%60, at%60, at/3600, at/60%60, at%60, (route*7+stop)%(stopsPerTrip*10), stop+1)
+			}
+		}
+	}
+
+	return map[string][]byte{
+		"routes.txt":     []byte(strings.Join(routeRows, "\n") + "\n"),
+		"stops.txt":      []byte(strings.Join(stopRows, "\n") + "\n"),
+		"trips.txt":      []byte(trips.String()),
+		"shapes.txt":     []byte(shapes.String()),
+		"stop_times.txt": []byte(stopTimes.String()),
+	}
+}
+
+// BenchmarkParseSchedule shows what parsing the files at the same time saves over parsing them one after another
+func BenchmarkParseSchedule(b *testing.B) {
+	files := syntheticSchedule(150, 40, 25)
+
+	b.Run("sequential", func(b *testing.B) {
+		for b.Loop() {
+			for _, parser := range scheduleParsers(&Schedule{}) {
+				if err := parser.parse(files[parser.filename]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("concurrent", func(b *testing.B) {
+		for b.Loop() {
+			if err := parseFiles(files, scheduleParsers(&Schedule{})); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestParseSchedule(t *testing.T) {
+	parsed, err := parseSchedule(syntheticSchedule(3, 4, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Routes) != 3 || len(parsed.Trips["2"]) != 4 || len(parsed.Shapes) != 12 || len(parsed.StopTimes["0_1_2_3"]) != 5 || len(parsed.Stops) != 50 {
+		t.Errorf("parsed %d routes, %d trips of route 2, %d shapes, %d stop times of a trip and %d stops",
+			len(parsed.Routes), len(parsed.Trips["2"]), len(parsed.Shapes), len(parsed.StopTimes["0_1_2_3"]), len(parsed.Stops))
+	}
+
+	// one broken file fails the whole schedule
+	files := syntheticSchedule(3, 4, 5)
+	files["stops.txt"] = []byte("stop_id,stop_name,stop_lat,stop_lon\n1_1,\"Unterminated,45.8,15.9\n")
+	if _, err := parseSchedule(files); err == nil || !strings.Contains(err.Error(), "stops.txt") {
+		t.Errorf("error %v, want one about stops.txt", err)
+	}
+}
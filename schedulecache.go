@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+// the unzipped schedule files are kept in the data directory, in a directory named after the schedule version,
+// so a restart or a new service day doesn't download the same schedule again
+const scheduleCacheDir = "schedule"
+
+// what the schedule download was served with, needed to tell whether the cached files are still current
+const scheduleCacheHeaders = "headers.json"
+
+type cachedScheduleHeaders struct {
+	ContentDisposition string `json:"content_disposition"`
+	LastModified       string `json:"last_modified"`
+}
+
+var unsafePathCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// scheduleCachePath is the directory the files of the schedule served with these headers are cached in
+func scheduleCachePath(headers http.Header) string {
+	version := "unversioned"
+	if match := scheduleVersionPattern.FindStringSubmatch(headers.Get("Content-Disposition")); match != nil {
+		version = unsafePathCharacters.ReplaceAllString(match[1], "_")
+	}
+	// dots are fine inside a name, but on their own they'd point outside the cache
+	if version == "." || version == ".." {
+		version = "unversioned"
+	}
+	return filepath.Join(dataPath(scheduleCacheDir), version)
+}
+
+// cacheScheduleFiles replaces the cached schedule with this one
+func cacheScheduleFiles(files map[string][]byte, headers http.Header) error {
+	cached := cachedScheduleHeaders{
+		ContentDisposition: headers.Get("Content-Disposition"),
+		LastModified:       headers.Get("Last-Modified"),
+	}
+	// without either there's no way to know later whether the files are current
+	if cached.ContentDisposition == "" && cached.LastModified == "" {
+		return nil
+	}
+
+	if err := os.RemoveAll(dataPath(scheduleCacheDir)); err != nil {
+		return err
+	}
+	dir := scheduleCachePath(headers)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	// written last, files without it are an interrupted write and never read
+	data, _ := json.Marshal(cached)
+	return os.WriteFile(filepath.Join(dir, scheduleCacheHeaders), data, 0o644)
+}
+
+// loadCachedScheduleFiles returns the cached schedule files if the server confirms they're still its current schedule
+func loadCachedScheduleFiles() (map[string][]byte, http.Header, error) {
+	matches, err := filepath.Glob(filepath.Join(dataPath(scheduleCacheDir), "*", scheduleCacheHeaders))
+	if err != nil || len(matches) != 1 {
+		return nil, nil, fmt.Errorf("No cached schedule")
+	}
+	dir := filepath.Dir(matches[0])
+
+	data, err := os.ReadFile(filepath.Join(dir, scheduleCacheHeaders))
+	if err != nil {
+		return nil, nil, err
+	}
+	var cached cachedScheduleHeaders
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, nil, fmt.Errorf("Could not parse the cached headers: %v", err)
+	}
+
+	outdated, err := isScheduleOutdated(cached.ContentDisposition, cached.LastModified)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not check whether the cached schedule is current: %v", err)
+	}
+	if outdated {
+		return nil, nil, fmt.Errorf("The cached schedule is outdated")
+	}
+
+	files := map[string][]byte{}
+	for _, name := range slices.Concat(scheduleFiles, optionalScheduleFiles) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) && !slices.Contains(scheduleFiles, name) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		files[name] = data
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Disposition", cached.ContentDisposition)
+	headers.Set("Last-Modified", cached.LastModified)
+	return files, headers, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScheduleCache(t *testing.T) {
+	upstream := startFakeUpstream(t)
+	setSchedule(t, schedule)
+
+	if err := loadSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if downloads := upstream.scheduleDownloads.Load(); downloads != 1 {
+		t.Fatalf("%d downloads of the first schedule, want 1", downloads)
+	}
+
+	// a restart finds the files cached and the server confirms they're current
+	setSchedule(t, &Schedule{})
+	if err := loadSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if downloads := upstream.scheduleDownloads.Load(); downloads != 1 {
+		t.Errorf("%d downloads after a restart, want the cached schedule to be used", downloads)
+	}
+	if !isScheduleAvailable() || getScheduleVersion() != "000-00001" {
+		t.Errorf("the cached schedule %q wasn't loaded", getScheduleVersion())
+	}
+
+	// a new schedule replaces the cached one
+	upstream.scheduleFilename.Store("attachment; filename=zet-gtfs-scheduled-000-00002.zip")
+	if err := loadSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if downloads := upstream.scheduleDownloads.Load(); downloads != 2 {
+		t.Errorf("%d downloads after a new schedule, want 2", downloads)
+	}
+	cached, _ := filepath.Glob(filepath.Join(dataPath(scheduleCacheDir), "*"))
+	if len(cached) != 1 || filepath.Base(cached[0]) != "000-00002" {
+		t.Errorf("cached schedules %v, want only 000-00002", cached)
+	}
+
+	// without the headers, written last, the files are an interrupted write
+	if err := os.Remove(filepath.Join(cached[0], scheduleCacheHeaders)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadCachedScheduleFiles(); err == nil {
+		t.Error("the files of an interrupted write were used")
+	}
+}
+
+func TestCacheScheduleFilesWithoutHeaders(t *testing.T) {
+	previous := *dataDir
+	*dataDir = t.TempDir()
+	t.Cleanup(func() { *dataDir = previous })
+
+	// there'd be no telling later whether they're current
+	if err := cacheScheduleFiles(map[string][]byte{"trips.txt": nil}, http.Header{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dataPath(scheduleCacheDir)); !os.IsNotExist(err) {
+		t.Errorf("files without headers were cached: %v", err)
+	}
+}
+
+func TestScheduleCachePath(t *testing.T) {
+	tests := []struct {
+		contentDisposition string
+		want               string
+	}{
+		{"attachment; filename=zet-gtfs-scheduled-000-00369.zip", "000-00369"},
+		{"attachment; filename=zet-gtfs-scheduled-../../etc.zip", ".._.._etc"},
+		{"attachment; filename=zet-gtfs-scheduled-...zip", "unversioned"},
+		{"attachment; filename=gtfs.zip", "unversioned"},
+		{"", "unversioned"},
+	}
+	for _, tt := range tests {
+		headers := http.Header{}
+		headers.Set("Content-Disposition", tt.contentDisposition)
+		path := scheduleCachePath(headers)
+		if filepath.Base(path) != tt.want || !strings.HasPrefix(path, dataPath(scheduleCacheDir)) {
+			t.Errorf("%q is cached in %s, want %s inside %s", tt.contentDisposition, path, tt.want, dataPath(scheduleCacheDir))
+		}
+	}
+}
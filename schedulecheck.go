@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var scheduleCheckInterval = flag.Duration("schedule-check-interval", 10*time.Minute, "how often the schedule is checked for a new version, on top of whenever the feed has unknown trips, 0 for only then")
+
+// scheduleChecker checks the schedule's freshness off the poll loop, so a slow schedule server never delays vehicle updates
+type scheduleChecker struct {
+	// asks for a check right away, a request while one is pending is merged into it
+	checkNow chan struct{}
+	// whether the latest check found the loaded schedule current
+	upToDate atomic.Bool
+	// a check requested by an operator runs alongside the background one, they take turns
+	running sync.Mutex
+}
+
+var scheduleCheck = &scheduleChecker{checkNow: make(chan struct{}, 1)}
+
+// request asks for a check without waiting for it
+func (c *scheduleChecker) request() {
+	select {
+	case c.checkNow <- struct{}{}:
+	default:
+	}
+}
+
+// run reloads the schedule whenever it's for another service day or the server has a newer one
+func (c *scheduleChecker) run() {
+	var tick <-chan time.Time
+	if *scheduleCheckInterval > 0 {
+		ticker := time.NewTicker(*scheduleCheckInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-c.checkNow:
+		}
+
+		if _, err := c.check(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// check reloads the schedule right away if it's outdated, reporting whether it did.
+// When the server can't be asked, upToDate keeps the previous check's answer
+func (c *scheduleChecker) check() (bool, error) {
+	c.running.Lock()
+	defer c.running.Unlock()
+
+	// trips filtered for yesterday's service are reloaded too since today's might be the ones missing
+	if !isScheduleForAnotherDay() {
+		stale, err := isTripsDataStale()
+		if err != nil {
+			return false, err
+		}
+		if !stale {
+			c.upToDate.Store(true)
+			return false, nil
+		}
+	}
+
+	c.upToDate.Store(false)
+	log.Println("Refetching trips data, the loaded schedule is outdated")
+	if err := loadSchedule(); err != nil {
+		return false, fmt.Errorf("Could not refetch trips data: %v", err)
+	}
+	c.upToDate.Store(true)
+	return true, nil
+}
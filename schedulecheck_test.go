@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+// a schedule server which can't be asked doesn't make the loaded schedule look current
+func TestScheduleCheckServerDown(t *testing.T) {
+	upstream := startFakeUpstream(t)
+	setSchedule(t, schedule)
+	previous := scheduleCheck.upToDate.Load()
+	t.Cleanup(func() { scheduleCheck.upToDate.Store(previous) })
+
+	if err := loadSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	upstream.scheduleDown.Store(true)
+
+	for _, upToDate := range []bool{false, true} {
+		scheduleCheck.upToDate.Store(upToDate)
+		reloaded, err := scheduleCheck.check()
+		if err == nil || reloaded {
+			t.Errorf("check against a server that's down: reloaded %v, error %v, want an error", reloaded, err)
+		}
+		if scheduleCheck.upToDate.Load() != upToDate {
+			t.Errorf("the failed check changed upToDate from %v", upToDate)
+		}
+	}
+
+	upstream.scheduleDown.Store(false)
+	if reloaded, err := scheduleCheck.check(); err != nil || reloaded || !scheduleCheck.upToDate.Load() {
+		t.Errorf("check once the server is back: reloaded %v, error %v, up to date %v", reloaded, err, scheduleCheck.upToDate.Load())
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+// boundingBox is a flag given as minLat,minLon,maxLat,maxLon
+type boundingBox struct {
+	MinLat, MinLon, MaxLat, MaxLon float64
+}
+
+func (b *boundingBox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.MinLat, b.MinLon, b.MaxLat, b.MaxLon)
+}
+
+func (b *boundingBox) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return fmt.Errorf("Expected minLat,minLon,maxLat,maxLon, got %q", value)
+	}
+
+	coordinates := [4]float64{}
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("Invalid coordinate %q: %v", part, err)
+		}
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return fmt.Errorf("Invalid coordinate %q", part)
+		}
+		coordinates[i] = n
+	}
+
+	parsed := boundingBox{MinLat: coordinates[0], MinLon: coordinates[1], MaxLat: coordinates[2], MaxLon: coordinates[3]}
+	if parsed.MinLat >= parsed.MaxLat || parsed.MinLon >= parsed.MaxLon {
+		return fmt.Errorf("The minimums have to be below the maximums, got %q", value)
+	}
+	*b = parsed
+	return nil
+}
+
+func (b *boundingBox) contains(lat, lon float64) bool {
+	return b.MinLat <= lat && lat <= b.MaxLat && b.MinLon <= lon && lon <= b.MaxLon
+}
+
+// Zagreb's metropolitan area, with some room to spare
+var serviceArea = boundingBox{MinLat: 45.55, MinLon: 15.55, MaxLat: 46.05, MaxLon: 16.35}
+
+func init() {
+	flag.Var(&serviceArea, "service-bbox", "minLat,minLon,maxLat,maxLon outside of which vehicle positions are dropped as garbage")
+}
+
+// dropOutsideServiceArea leaves out the vehicles reporting positions outside of the service area,
+// which also catches the ones without a GPS fix reporting (0, 0)
+func dropOutsideServiceArea(vehicles []*gtfs.VehiclePosition) ([]*gtfs.VehiclePosition, int) {
+	inside := make([]*gtfs.VehiclePosition, 0, len(vehicles))
+	for _, v := range vehicles {
+		if serviceArea.contains(float64(v.GetPosition().GetLatitude()), float64(v.GetPosition().GetLongitude())) {
+			inside = append(inside, v)
+		}
+	}
+	return inside, len(vehicles) - len(inside)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+type ShapeID string
+
+// Shape is the polyline a trip follows, ordered by shape_pt_sequence
+type Shape struct {
+	Points []Point
+	// Distances[i] is the distance in meters along the shape from the first point to Points[i]
+	Distances []float64
+	// direction_id of the trips the shape was drawn for, trips of the other direction sharing it run it backwards
+	Direction string
+}
+
+func (shape Shape) length() float64 {
+	if len(shape.Distances) == 0 {
+		return 0
+	}
+	return shape.Distances[len(shape.Distances)-1]
+}
+
+func getShape(shapeID ShapeID) (Shape, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	shape, exists := schedule.Shapes[shapeID]
+	return shape, exists && len(shape.Points) > 0
+}
+
+func parseShapes(data []byte) (map[ShapeID]Shape, error) {
+	table, err := parseCSV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	type shapePoint struct {
+		sequence int
+		point    Point
+	}
+	points := map[ShapeID][]shapePoint{}
+	for _, row := range table.rows {
+		lat, errLat := strconv.ParseFloat(table.get(row, "shape_pt_lat"), 64)
+		lon, errLon := strconv.ParseFloat(table.get(row, "shape_pt_lon"), 64)
+		sequence, errSeq := strconv.Atoi(table.get(row, "shape_pt_sequence"))
+		if errLat != nil || errLon != nil || errSeq != nil {
+			continue
+		}
+
+		shapeID := ShapeID(table.get(row, "shape_id"))
+		points[shapeID] = append(points[shapeID], shapePoint{sequence: sequence, point: Point{Lat: lat, Lon: lon}})
+	}
+
+	result := make(map[ShapeID]Shape, len(points))
+	for shapeID, shapePoints := range points {
+		slices.SortFunc(shapePoints, func(a, b shapePoint) int { return a.sequence - b.sequence })
+		shape := Shape{
+			Points:    make([]Point, 0, len(shapePoints)),
+			Distances: make([]float64, 0, len(shapePoints)),
+		}
+		for i, sp := range shapePoints {
+			distance := 0.0
+			if i > 0 {
+				distance = shape.Distances[i-1] + geoDistance(shape.Points[i-1], sp.point)
+			}
+			shape.Points = append(shape.Points, sp.point)
+			shape.Distances = append(shape.Distances, distance)
+		}
+		result[shapeID] = shape
+	}
+	return result, nil
+}
+
+// longitudeScale is how much shorter a degree of longitude is than a degree of latitude around p,
+// at Zagreb's latitude it's noticeably shorter, scaling longitudes by it gives a roughly equidistant plane
+func longitudeScale(p Point) float64 {
+	return math.Cos(p.Lat * math.Pi / 180)
+}
+
+// scaledDistance returns the distance between p and q in degrees of latitude, on the plane projectOntoSegment works in
+func scaledDistance(p, q Point) float64 {
+	return math.Hypot((q.Lon-p.Lon)*longitudeScale(p), q.Lat-p.Lat)
+}
+
+// projectOntoSegment returns the point on the segment a-b closest to p, and how far along the segment it is (0-1)
+func projectOntoSegment(p, a, b Point) (Point, float64) {
+	scale := longitudeScale(p)
+
+	dx := (b.Lon - a.Lon) * scale
+	dy := b.Lat - a.Lat
+	lengthSquared := dx*dx + dy*dy
+	if lengthSquared == 0 {
+		return a, 0
+	}
+
+	t := ((p.Lon-a.Lon)*scale*dx + (p.Lat-a.Lat)*dy) / lengthSquared
+	t = max(0, min(1, t))
+	return Point{Lat: a.Lat + t*(b.Lat-a.Lat), Lon: a.Lon + t*(b.Lon-a.Lon)}, t
+}
+
+// snap returns the point on the shape closest to p and the distance in meters along the shape to it
+func (shape Shape) snap(p Point) (Point, float64) {
+	if len(shape.Points) == 1 {
+		return shape.Points[0], 0
+	}
+
+	closest := p
+	closestDistance := math.Inf(1)
+	along := 0.0
+	for i := 1; i < len(shape.Points); i++ {
+		projected, t := projectOntoSegment(p, shape.Points[i-1], shape.Points[i])
+		if distance := scaledDistance(p, projected); distance < closestDistance {
+			closest = projected
+			closestDistance = distance
+			along = shape.Distances[i-1] + t*(shape.Distances[i]-shape.Distances[i-1])
+		}
+	}
+	return closest, along
+}
+
+// segmentLength returns the length in meters of the shape's segment at `along` meters from its start
+func (shape Shape) segmentLength(along float64) float64 {
+	i, _ := slices.BinarySearch(shape.Distances, along)
+	if i == 0 || i >= len(shape.Distances) {
+		return 0
+	}
+	return shape.Distances[i] - shape.Distances[i-1]
+}
+
+// isOffRoute reports whether a point `distance` meters away from the shape at `along` is further than the threshold,
+// the longer the segment the more a straight line between sparse points cuts corners of the actual road,
+// so long segments tolerate proportionally more
+func (shape Shape) isOffRoute(distance, along, threshold float64) bool {
+	return distance > threshold+offRouteSegmentTolerance*shape.segmentLength(along)
+}
+
+// share of the segment's length added to the off-route threshold
+const offRouteSegmentTolerance = 0.1
+
+// progress returns how far along the shape (0-1) a point that is `along` meters from its start is,
+// for a trip going in the given direction
+func (shape Shape) progress(along float64, direction string) (float64, bool) {
+	total := shape.length()
+	if total == 0 {
+		return 0, false
+	}
+
+	progress := along / total
+	if shape.Direction != "" && direction != shape.Direction {
+		progress = 1 - progress
+	}
+	return progress, true
+}
+
+// shapeHandler returns a shape's points as [lat, lon] pairs, or as an encoded polyline with ?format=polyline
+func shapeHandler(w http.ResponseWriter, r *http.Request) {
+	polyline, err := wantsPolyline(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	shapeID := ShapeID(r.PathValue("id"))
+	shape, exists := getShape(shapeID)
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Unknown shape %q", shapeID))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(struct {
+		ShapeGeometry
+		Units Units `json:"units"`
+	}{
+		ShapeGeometry: newShapeGeometry(shapeID, shape, polyline),
+		Units:         apiUnits(),
+	})
+}
+
+type ShapeGeometry struct {
+	ShapeID  ShapeID      `json:"shape_id"`
+	Length   Distance     `json:"length"`
+	Points   [][2]float64 `json:"points,omitempty"`
+	Polyline string       `json:"polyline,omitempty"`
+}
+
+// newShapeGeometry has the shape's points as [lat, lon] pairs, or as an encoded polyline
+func newShapeGeometry(shapeID ShapeID, shape Shape, polyline bool) ShapeGeometry {
+	geometry := ShapeGeometry{
+		ShapeID: shapeID,
+		Length:  Distance(shape.length()),
+	}
+	if polyline {
+		geometry.Polyline = encodePolyline(shape.Points)
+	} else {
+		geometry.Points = make([][2]float64, len(shape.Points))
+		for i, p := range shape.Points {
+			geometry.Points[i] = [2]float64{p.Lat, p.Lon}
+		}
+	}
+	return geometry
+}
+
+type RouteShape struct {
+	ShapeGeometry
+	// how many of the counted trips follow it
+	Trips int `json:"trips"`
+}
+
+// getRouteShapes returns the route's distinct shapes by direction_id, the most common first,
+// counted over the trips its vehicles are running, or over all its scheduled trips when none are
+func getRouteShapes(routeID RouteID, vehicles Vehicles, polyline bool) map[string][]RouteShape {
+	type key struct {
+		direction string
+		shapeID   ShapeID
+	}
+	counts := map[key]int{}
+	for _, v := range vehicles {
+		if trip, exists := getTrip(routeID, v.tripID); exists && trip.ShapeID != "" {
+			counts[key{trip.Direction, trip.ShapeID}]++
+		}
+	}
+	if len(counts) == 0 {
+		mu.RLock()
+		for _, trip := range schedule.Trips[routeID] {
+			if trip.ShapeID != "" {
+				counts[key{trip.Direction, trip.ShapeID}]++
+			}
+		}
+		mu.RUnlock()
+	}
+
+	byDirection := map[string][]RouteShape{}
+	for k, count := range counts {
+		shape, exists := getShape(k.shapeID)
+		if !exists {
+			continue
+		}
+		byDirection[k.direction] = append(byDirection[k.direction], RouteShape{
+			ShapeGeometry: newShapeGeometry(k.shapeID, shape, polyline),
+			Trips:         count,
+		})
+	}
+	for _, shapes := range byDirection {
+		slices.SortFunc(shapes, func(a, b RouteShape) int {
+			if a.Trips != b.Trips {
+				return b.Trips - a.Trips
+			}
+			return compareNatural(string(a.ShapeID), string(b.ShapeID))
+		})
+	}
+	return byDirection
+}
+
+// routeShapeHandler returns a route's geometry for clients which don't deal in shape IDs
+func routeShapeHandler(w http.ResponseWriter, r *http.Request) {
+	polyline, err := wantsPolyline(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	routeID := RouteID(r.PathValue("id"))
+	if _, exists := getRoute(routeID); !exists {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Unknown route %q", routeID))
+		return
+	}
+
+	response := struct {
+		RouteID    RouteID                 `json:"route_id"`
+		Directions map[string][]RouteShape `json:"directions"`
+		Units      Units                   `json:"units"`
+	}{
+		Units:      apiUnits(),
+		RouteID:    routeID,
+		Directions: getRouteShapes(routeID, allVehicles.Load().(*Snapshot).Routes[routeID], polyline),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestShape builds a shape through the points, with distances along it the way parseShapes computes them
+func newTestShape(points ...Point) Shape {
+	shape := Shape{Points: points, Distances: make([]float64, len(points))}
+	for i := 1; i < len(points); i++ {
+		shape.Distances[i] = shape.Distances[i-1] + geoDistance(points[i-1], points[i])
+	}
+	return shape
+}
+
+func TestShapeSnapPicksSegmentInMeters(t *testing.T) {
+	p := Point{Lat: 45.8, Lon: 15.97}
+
+	// an east-west segment 0.0008° of latitude (~89m) north of p, and a north-south one 0.001° of longitude (~78m) east of it,
+	// in unscaled degrees the first one looks closer
+	shape := newTestShape(
+		Point{Lat: p.Lat + 0.0008, Lon: p.Lon - 0.003},
+		Point{Lat: p.Lat + 0.0008, Lon: p.Lon + 0.003},
+		Point{Lat: p.Lat + 0.05, Lon: p.Lon + 0.05},
+		Point{Lat: p.Lat + 0.003, Lon: p.Lon + 0.001},
+		Point{Lat: p.Lat - 0.003, Lon: p.Lon + 0.001},
+	)
+
+	snapped, along := shape.snap(p)
+	want := Point{Lat: p.Lat, Lon: p.Lon + 0.001}
+	if geoDistance(snapped, want) > 0.01 {
+		t.Errorf("snapped to %v, want %v", snapped, want)
+	}
+	wantAlong := shape.Distances[3] + geoDistance(shape.Points[3], want)
+	if math.Abs(along-wantAlong) > 0.5 {
+		t.Errorf("along %.1fm, want %.1fm", along, wantAlong)
+	}
+}
+
+func TestShapeSnapSinglePoint(t *testing.T) {
+	shape := newTestShape(Point{Lat: 45.8, Lon: 15.97})
+	if snapped, along := shape.snap(Point{Lat: 45.81, Lon: 15.98}); snapped != shape.Points[0] || along != 0 {
+		t.Errorf("snapped to %v at %.1fm, want the only point at 0m", snapped, along)
+	}
+}
+
+func TestShapeHandlerFormats(t *testing.T) {
+	shape := newTestShape(jelacicSquare, mainStation)
+	setSchedule(t, &Schedule{Shapes: map[ShapeID]Shape{"6_1": shape}})
+
+	get := func(target string) (*httptest.ResponseRecorder, ShapeGeometry) {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.SetPathValue("id", "6_1")
+		recorder := httptest.NewRecorder()
+		shapeHandler(recorder, request)
+
+		var geometry ShapeGeometry
+		if recorder.Code == http.StatusOK {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &geometry); err != nil {
+				t.Fatalf("%s: %v\n%s", target, err, recorder.Body)
+			}
+		}
+		return recorder, geometry
+	}
+
+	if _, geometry := get("/shapes/6_1"); len(geometry.Points) != 2 || geometry.Polyline != "" {
+		t.Errorf("default format: %+v, want only the points", geometry)
+	}
+	_, geometry := get("/shapes/6_1?format=polyline")
+	if geometry.Points != nil || geometry.Polyline != encodePolyline(shape.Points) {
+		t.Errorf("polyline format: %+v, want only the polyline", geometry)
+	}
+	if decoded := decodePolyline(t, geometry.Polyline); len(decoded) != 2 || geoDistance(decoded[1], mainStation) > 1 {
+		t.Errorf("polyline decoded as %v", decoded)
+	}
+	if recorder, _ := get("/shapes/6_1?format=geojson"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestShapeHandlerUnits(t *testing.T) {
+	shape := newTestShape(jelacicSquare, mainStation)
+	setSchedule(t, &Schedule{Shapes: map[ShapeID]Shape{"6_1": shape}})
+	t.Cleanup(func() { setUnits("metric") })
+
+	get := func() (length float64, units Units) {
+		request := httptest.NewRequest(http.MethodGet, "/shapes/6_1", nil)
+		request.SetPathValue("id", "6_1")
+		recorder := httptest.NewRecorder()
+		shapeHandler(recorder, request)
+
+		var response struct {
+			Length float64 `json:"length"`
+			Units  Units   `json:"units"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%v\n%s", err, recorder.Body)
+		}
+		return response.Length, response.Units
+	}
+
+	meters, metric := get()
+	if math.Abs(meters-shape.length()) > 1e-6 || metric != (Units{Distance: "m", Speed: "km/h"}) {
+		t.Errorf("metric: length %v in %+v, want %v in meters", meters, metric, shape.length())
+	}
+
+	if err := setUnits("imperial"); err != nil {
+		t.Fatal(err)
+	}
+	feet, imperial := get()
+	if math.Abs(feet-shape.length()/0.3048) > 1e-6 || imperial != (Units{Distance: "ft", Speed: "mph"}) {
+		t.Errorf("imperial: length %v in %+v, want %v in feet", feet, imperial, shape.length()/0.3048)
+	}
+}
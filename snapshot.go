@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type vehicleRef struct {
+	routeID RouteID
+	index   int
+}
+
+// Snapshot is the state of the fleet after processing a single feed
+type Snapshot struct {
+	Routes map[RouteID]Vehicles
+	// the feed header's timestamp
+	Timestamp uint64
+	// counts the snapshots pushed to the streams, one skipped on a stream means an update was missed
+	Sequence uint64
+	// computed once here so clients don't have to sum the routes themselves
+	TotalVehicles int
+	TotalRoutes   int
+	// vehicles on each route which has any
+	RouteCounts routeCounts
+	// trips with predictions but no vehicle yet, only with -predicted-vehicles
+	Predicted []PredictedVehicle
+	// vehicle ID to its position in Routes
+	index map[string]vehicleRef
+
+	hashOnce sync.Once
+	hash     uint64
+}
+
+// snapshotSequence is the Sequence of the latest published snapshot
+var snapshotSequence atomic.Uint64
+
+func newSnapshot(routes map[RouteID]Vehicles, timestamp uint64) *Snapshot {
+	snapshot := &Snapshot{Routes: routes, Timestamp: timestamp, RouteCounts: routeCounts{}, index: map[string]vehicleRef{}}
+	for routeID, vehicles := range routes {
+		for i, v := range vehicles {
+			snapshot.index[v.ID] = vehicleRef{routeID: routeID, index: i}
+		}
+		if len(vehicles) > 0 {
+			snapshot.RouteCounts[routeID] = len(vehicles)
+		}
+	}
+	snapshot.TotalVehicles = len(snapshot.index)
+	snapshot.TotalRoutes = len(snapshot.RouteCounts)
+	return snapshot
+}
+
+func (s *Snapshot) vehicleCount() int {
+	return len(s.index)
+}
+
+// changedSince tells whether a vehicle appeared, disappeared, switched routes or moved at least minMovement meters since the previous snapshot
+func (s *Snapshot) changedSince(previous *Snapshot, minMovement float64) bool {
+	if s.vehicleCount() != previous.vehicleCount() {
+		return true
+	}
+	for routeID, vehicles := range s.Routes {
+		for _, v := range vehicles {
+			previousRouteID, previousVehicle, exists := previous.getVehicle(v.ID)
+			if !exists || previousRouteID != routeID {
+				return true
+			}
+			from := Point{Lat: float64(previousVehicle.Latitude), Lon: float64(previousVehicle.Longitude)}
+			to := Point{Lat: float64(v.Latitude), Lon: float64(v.Longitude)}
+			if geoDistance(from, to) >= minMovement {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Snapshot) getVehicle(id string) (RouteID, Vehicle, bool) {
+	ref, exists := s.index[id]
+	if !exists {
+		return "", Vehicle{}, false
+	}
+	return ref.routeID, s.Routes[ref.routeID][ref.index], true
+}
+
+func vehicleByIDHandler(w http.ResponseWriter, r *http.Request) {
+	routeID, vehicle, exists := allVehicles.Load().(*Snapshot).getVehicle(r.PathValue("id"))
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "Vehicle not found")
+		return
+	}
+
+	response := struct {
+		RouteID RouteID `json:"route_id"`
+		Vehicle Vehicle `json:"vehicle"`
+		Units   Units   `json:"units"`
+	}{
+		RouteID: routeID,
+		Vehicle: vehicle,
+		Units:   apiUnits(),
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
+
+// contentHash hashes the vehicles as they're serialized, a feed with nothing new hashes the same as the one before.
+// Map keys are marshalled sorted and buildRoutes sorts each route's vehicles, so the same fleet always serializes the same.
+func (s *Snapshot) contentHash() uint64 {
+	s.hashOnce.Do(func() {
+		h := fnv.New64a()
+		encoder := json.NewEncoder(h)
+		encoder.Encode(s.Routes)
+		encoder.Encode(s.Predicted)
+		s.hash = h.Sum64()
+	})
+	return s.hash
+}
+
+// etag identifies a representation of the snapshot, it only changes when the vehicles do,
+// the feed goes stale or its vehicles expire
+func (s *Snapshot) etag(representation string) string {
+	if isFeedStale() {
+		representation += "-stale"
+	}
+	return fmt.Sprintf(`W/"%016x-%s"`, s.contentHash(), representation)
+}
+
+// notModified sets the ETag and reports whether the client already has it, in which case a 304 has been sent
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		// weak comparison, W/ prefixes don't matter
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// vehicleCountHandler is for status displays which only need the numbers, without encoding any positions
+func vehicleCountHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := allVehicles.Load().(*Snapshot)
+	if notModified(w, r, snapshot.etag("count")) {
+		return
+	}
+
+	response := struct {
+		Total    int         `json:"total"`
+		PerRoute routeCounts `json:"per_route"`
+	}{
+		Total:    snapshot.TotalVehicles,
+		PerRoute: snapshot.RouteCounts,
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(response)
+}
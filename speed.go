@@ -0,0 +1,37 @@
+package main
+
+// calculateSpeeds fills in the speed of the vehicles the feed didn't report one for,
+// from the distance to their previous report over the time between the two
+func calculateSpeeds(old *Snapshot, routes map[RouteID]Vehicles) {
+	teleportThreshold := getTunables().TeleportThreshold
+	for routeID, vehicles := range routes {
+		for i, v := range vehicles {
+			if v.Speed != nil {
+				continue
+			}
+			_, oldVehicle, exists := old.getVehicle(v.ID)
+			if !exists || v.reportedAt == 0 || oldVehicle.reportedAt == 0 {
+				continue
+			}
+
+			// no new report since, the vehicle is where it was going as fast as it was
+			if v.reportedAt == oldVehicle.reportedAt {
+				routes[routeID][i].Speed = oldVehicle.Speed
+				continue
+			}
+			if v.reportedAt < oldVehicle.reportedAt {
+				continue
+			}
+
+			from := Point{Lat: float64(oldVehicle.RawLatitude), Lon: float64(oldVehicle.RawLongitude)}
+			to := Point{Lat: float64(v.RawLatitude), Lon: float64(v.RawLongitude)}
+			distance := geoDistance(from, to)
+			// a GPS glitch would make for a supersonic tram
+			if distance > teleportThreshold {
+				continue
+			}
+			speed := Speed(distance / float64(v.reportedAt-oldVehicle.reportedAt) * 3.6)
+			routes[routeID][i].Speed = &speed
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// SSE event names, so clients can tell the messages apart with addEventListener
+const (
+	sseEventVehicles = "vehicles"
+	sseEventAlerts   = "alerts" // reserved for service alerts
+	// sent when a new schedule is loaded, so clients know to refetch static data
+	sseEventScheduleUpdated = "schedule_updated"
+)
+
+func writeSSEEvent(w io.Writer, event string, data []byte) {
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+var ssePath = flag.String("sse-path", "/events", "path the SSE stream is served at, e.g. when mounted under a reverse-proxy prefix")
+
+var sseClients atomic.Int64
+
+// vehiclesMessage is the payload of a vehicles event, legacy clients get just the map of vehicles
+type vehiclesMessage struct {
+	Vehicles      map[RouteID]Vehicles `json:"vehicles"`
+	Predicted     []PredictedVehicle   `json:"predicted,omitempty"`
+	TotalVehicles int                  `json:"total_vehicles"`
+	TotalRoutes   int                  `json:"total_routes"`
+	// the feed is older than -stale-after, the vehicles are where they were last seen
+	Stale    bool   `json:"stale"`
+	Sequence uint64 `json:"sequence"`
+	Units    Units  `json:"units"`
+}
+
+// countsMessage is what mode=counts clients get, shaped like /vehicles/count
+type countsMessage struct {
+	Total    int         `json:"total"`
+	PerRoute routeCounts `json:"per_route"`
+	Sequence uint64      `json:"sequence"`
+}
+
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	// binary is a compact alternative to the full JSON, see encodeBinarySnapshot for the layout
+	encoding := r.URL.Query().Get("encoding")
+	if encoding != "" && encoding != "json" && encoding != "binary" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("encoding must be json or binary, got %q", encoding))
+		return
+	}
+	if encoding == "binary" && r.URL.Query().Get("mode") != "" {
+		writeJSONError(w, http.StatusBadRequest, "encoding=binary always sends whole snapshots, it can't be combined with mode")
+		return
+	}
+
+	maxClients := getTunables().MaxSSEClients
+	if clients := sseClients.Add(1); maxClients > 0 && clients > maxClients {
+		sseClients.Add(-1)
+		log.Printf("Rejecting SSE client, already serving %d\n", clients-1)
+		w.Header().Set("Retry-After", "10")
+		writeJSONError(w, http.StatusServiceUnavailable, "Too many clients, try again later")
+		return
+	}
+	defer sseClients.Add(-1)
+
+	log.Println("SSE client connected")
+
+	// Setup headers for SSE
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.ProtoMajor == 1 {
+		w.Header().Set("Connection", "keep-alive") // connection-specific headers are forbidden in HTTP/2
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allowedOrigin) // optional
+	w.Header().Set("X-Accel-Buffering", "no")                    // otherwise nginx batches the events
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "Streaming unsupported!")
+		return
+	}
+
+	client := vehicleBroadcaster.subscribe()
+	defer vehicleBroadcaster.unsubscribe(client)
+
+	// older clients only listen for unnamed messages
+	eventName := sseEventVehicles
+	if r.URL.Query().Get("legacy") == "1" {
+		eventName = ""
+	}
+
+	// delta clients get a full snapshot first, then only what changed since the last one they got,
+	// counts clients only get how many vehicles each route has
+	mode := r.URL.Query().Get("mode")
+	deltaMode := mode == "delta"
+	var lastSent *Snapshot
+
+	send := func(snapshot *Snapshot) {
+		var data []byte
+		switch {
+		case encoding == "binary":
+			data = []byte(base64.StdEncoding.EncodeToString(encodeBinarySnapshot(snapshot, isFeedStale())))
+		case deltaMode:
+			delta := computeDelta(lastSent, snapshot)
+			delta.Stale = isFeedStale()
+			data, _ = json.Marshal(delta)
+		case mode == "counts":
+			data, _ = json.Marshal(countsMessage{
+				Total:    snapshot.TotalVehicles,
+				PerRoute: snapshot.RouteCounts,
+				Sequence: snapshot.Sequence,
+			})
+		case eventName == "":
+			data, _ = json.Marshal(snapshot.Routes)
+		default:
+			data, _ = json.Marshal(vehiclesMessage{
+				Vehicles:      snapshot.Routes,
+				Predicted:     snapshot.Predicted,
+				TotalVehicles: snapshot.TotalVehicles,
+				TotalRoutes:   snapshot.TotalRoutes,
+				Stale:         isFeedStale(),
+				Sequence:      snapshot.Sequence,
+				Units:         apiUnits(),
+			})
+		}
+		lastSent = snapshot
+		writeSSEEvent(w, eventName, data)
+		flusher.Flush()
+	}
+
+	// legacy clients would mistake it for vehicles, so they never get it
+	var scheduleUpdated <-chan struct{}
+	if eventName != "" {
+		scheduleUpdated = scheduleUpdatedSignal()
+	}
+
+	send(allVehicles.Load().(*Snapshot))
+	for {
+		select {
+		case snapshot := <-client.updates:
+			send(snapshot)
+		case <-scheduleUpdated:
+			scheduleUpdated = scheduleUpdatedSignal()
+			// deltas don't carry headsign or color changes, so the first snapshot built with the new schedule
+			// goes out whole, full and legacy clients get every snapshot whole anyway
+			lastSent = nil
+			data, _ := json.Marshal(struct {
+				ScheduleVersion  string `json:"schedule_version"`
+				ScheduleFilename string `json:"schedule_filename"`
+			}{
+				ScheduleVersion:  getScheduleVersion(),
+				ScheduleFilename: getScheduleFilename(),
+			})
+			writeSSEEvent(w, sseEventScheduleUpdated, data)
+			flusher.Flush()
+		case <-client.tooSlow:
+			log.Printf("SSE client disconnected for missing %d updates in a row\n", maxClientDrops)
+			return
+		case <-r.Context().Done():
+			log.Println("SSE client disconnected")
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+var startTime = time.Now()
+
+// realtime feed fetches, a fetch which returned something that couldn't be parsed counts as failed
+var feedFetchesSucceeded atomic.Int64
+var feedFetchesFailed atomic.Int64
+var feedConsecutiveFailures atomic.Int64
+
+// feeds older than the latest one, a cached response or clock skew upstream
+var feedTimestampRegressions atomic.Int64
+
+// seconds a feed can be older than the latest one by before it counts as a regression, rather than a plain repeat
+const feedRegressionTolerance = 5
+
+func checkFeedRegression(cached, received uint64) {
+	if received+feedRegressionTolerance >= cached {
+		return
+	}
+	feedTimestampRegressions.Add(1)
+	log.Printf("warning=feed_timestamp_regression cached=%d received=%d behind_seconds=%d\n", cached, received, cached-received)
+}
+
+// published for /debug/vars, computed on every read so they're never stale
+func init() {
+	expvar.Publish("vehicles_tracked", expvar.Func(func() any { return allVehicles.Load().(*Snapshot).vehicleCount() }))
+	expvar.Publish("sse_clients", expvar.Func(func() any { return sseClients.Load() }))
+	expvar.Publish("feed_fetches_succeeded", expvar.Func(func() any { return feedFetchesSucceeded.Load() }))
+	expvar.Publish("feed_fetches_failed", expvar.Func(func() any { return feedFetchesFailed.Load() }))
+	expvar.Publish("feed_malformed", expvar.Func(func() any { return malformedFeeds.Load() }))
+	expvar.Publish("feed_timestamp_regressions", expvar.Func(func() any { return feedTimestampRegressions.Load() }))
+	expvar.Publish("vehicles_departed", expvar.Func(func() any { return departedVehicles.Load() }))
+	expvar.Publish("feed_consecutive_failures", expvar.Func(func() any { return feedConsecutiveFailures.Load() }))
+	expvar.Publish("last_update", expvar.Func(func() any { return atomic.LoadUint64(&lastUpdateTimestamp) }))
+}
+
+type Stats struct {
+	UptimeSeconds    int64       `json:"uptime_seconds"`
+	TotalVehicles    int         `json:"total_vehicles"`
+	ActiveRoutes     int         `json:"active_routes"`
+	VehiclesPerRoute routeCounts `json:"vehicles_per_route"`
+	StartedAt        string      `json:"started_at"`
+	LastUpdate       uint64      `json:"last_update"` // feed timestamp, unix epoch
+	LastUpdateISO    string      `json:"last_update_iso"`
+	SSEClients       int64       `json:"sse_clients"`
+	FeedVersion      string      `json:"feed_version"`
+	ScheduleVersion  string      `json:"schedule_version"`
+	LastTickSeconds  float64     `json:"last_tick_seconds"` // processing the latest feed, without fetching it
+
+	FeedFetchesSucceeded    int64 `json:"feed_fetches_succeeded"`
+	FeedFetchesFailed       int64 `json:"feed_fetches_failed"`
+	FeedConsecutiveFailures int64 `json:"feed_consecutive_failures"`
+	FeedMalformed           int64 `json:"feed_malformed"`
+	FeedRegressions         int64 `json:"feed_timestamp_regressions"`
+	UnresolvedIDs           int64 `json:"unresolved_ids"`
+	VehiclesDeparted        int64 `json:"vehicles_departed"`
+}
+
+// routeCounts is encoded with its routes in natural order, encoding/json would put "13" before "2"
+type routeCounts map[RouteID]int
+
+func (c routeCounts) MarshalJSON() ([]byte, error) {
+	routeIDs := slices.SortedFunc(maps.Keys(c), compareRouteIDs)
+
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, routeID := range routeIDs {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		key, err := json.Marshal(routeID)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buffer, "%s:%d", key, c[routeID])
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func getStats() Stats {
+	snapshot := allVehicles.Load().(*Snapshot)
+	stats := Stats{
+		UptimeSeconds:    int64(time.Since(startTime).Seconds()),
+		TotalVehicles:    snapshot.TotalVehicles,
+		ActiveRoutes:     snapshot.TotalRoutes,
+		VehiclesPerRoute: snapshot.RouteCounts,
+		StartedAt:        formatZagrebTime(startTime.Unix()),
+		LastUpdate:       atomic.LoadUint64(&lastUpdateTimestamp),
+		SSEClients:       sseClients.Load(),
+		ScheduleVersion:  getScheduleVersion(),
+		LastTickSeconds:  time.Duration(lastTickDuration.Load()).Seconds(),
+
+		FeedFetchesSucceeded:    feedFetchesSucceeded.Load(),
+		FeedFetchesFailed:       feedFetchesFailed.Load(),
+		FeedConsecutiveFailures: feedConsecutiveFailures.Load(),
+		FeedMalformed:           malformedFeeds.Load(),
+		FeedRegressions:         feedTimestampRegressions.Load(),
+		UnresolvedIDs:           unresolvedIDs.Load(),
+		VehiclesDeparted:        departedVehicles.Load(),
+	}
+	stats.LastUpdateISO = formatZagrebTime(int64(stats.LastUpdate))
+	stats.FeedVersion, _ = feedVersion.Load().(string)
+	return stats
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	newJSONEncoder(w, r).Encode(getStats())
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+type StopID string
+type Stop struct {
+	ID   StopID  `json:"id"`
+	Name string  `json:"name"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+// StopTime is a trip's scheduled visit of a stop
+type StopTime struct {
+	Sequence uint32
+	StopID   StopID
+	// seconds since the start of the service day, can exceed 24h for trips running past midnight
+	Arrival   int
+	Departure int
+}
+
+func parseStops(data []byte) (map[StopID]Stop, error) {
+	table, err := parseCSV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[StopID]Stop, len(table.rows))
+	for _, row := range table.rows {
+		stopID := StopID(table.get(row, "stop_id"))
+		lat, _ := strconv.ParseFloat(table.get(row, "stop_lat"), 64)
+		lon, _ := strconv.ParseFloat(table.get(row, "stop_lon"), 64)
+		result[stopID] = Stop{ID: stopID, Name: table.get(row, "stop_name"), Lat: lat, Lon: lon}
+	}
+	return result, nil
+}
+
+func parseStopTimes(data []byte) (map[TripID][]StopTime, error) {
+	table, err := parseCSV(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[TripID][]StopTime{}
+	for _, row := range table.rows {
+		sequence, err := strconv.ParseUint(table.get(row, "stop_sequence"), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		arrival, errArrival := parseGTFSTime(table.get(row, "arrival_time"))
+		departure, errDeparture := parseGTFSTime(table.get(row, "departure_time"))
+		if errArrival != nil {
+			arrival = departure
+		}
+		if errDeparture != nil {
+			departure = arrival
+		}
+
+		tripID := TripID(table.get(row, "trip_id"))
+		result[tripID] = append(result[tripID], StopTime{
+			Sequence:  uint32(sequence),
+			StopID:    StopID(table.get(row, "stop_id")),
+			Arrival:   arrival,
+			Departure: departure,
+		})
+	}
+
+	for _, tripStopTimes := range result {
+		slices.SortFunc(tripStopTimes, func(a, b StopTime) int { return int(a.Sequence) - int(b.Sequence) })
+	}
+	return result, nil
+}
+
+// getNextStop returns the stop the vehicle is heading to, based on the stop it reports in the feed
+func getNextStop(tripID TripID, v *gtfs.VehiclePosition) (Stop, bool) {
+	if v.CurrentStopSequence == nil && v.StopId == nil {
+		return Stop{}, false
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	tripStopTimes := schedule.StopTimes[tripID]
+	idx := findReportedStop(tripStopTimes, v)
+
+	// once the vehicle is at the stop, it's heading to the following one
+	stoppedAt := v.GetCurrentStatus() == gtfs.VehiclePosition_STOPPED_AT
+
+	if idx == -1 {
+		// the trip isn't in the schedule, but the feed can still tell us where the vehicle is going
+		stop, exists := schedule.Stops[StopID(v.GetStopId())]
+		return stop, exists && !stoppedAt
+	}
+
+	if stoppedAt {
+		idx++
+		if idx >= len(tripStopTimes) {
+			return Stop{}, false
+		}
+	}
+
+	stop, exists := schedule.Stops[tripStopTimes[idx].StopID]
+	return stop, exists
+}
+
+// getStoppedAt returns the stop the vehicle is standing at, if the feed says it's at one
+func getStoppedAt(tripID TripID, v *gtfs.VehiclePosition) (Stop, bool) {
+	if v.GetCurrentStatus() != gtfs.VehiclePosition_STOPPED_AT || (v.CurrentStopSequence == nil && v.StopId == nil) {
+		return Stop{}, false
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	stopID := StopID(v.GetStopId())
+	tripStopTimes := schedule.StopTimes[tripID]
+	if idx := findReportedStop(tripStopTimes, v); idx != -1 {
+		stopID = tripStopTimes[idx].StopID
+	}
+	stop, exists := schedule.Stops[stopID]
+	return stop, exists
+}
+
+// findReportedStop returns the index of the stop the vehicle reports, by sequence if it has one or else by stop ID,
+// -1 if the trip doesn't have it
+func findReportedStop(tripStopTimes []StopTime, v *gtfs.VehiclePosition) int {
+	return slices.IndexFunc(tripStopTimes, func(st StopTime) bool {
+		if v.CurrentStopSequence != nil {
+			return st.Sequence == v.GetCurrentStopSequence()
+		}
+		return st.StopID == StopID(v.GetStopId())
+	})
+}
+
+// parseGTFSTime parses a HH:MM:SS time into seconds since the start of the service day,
+// hours go past 24 for trips which run after midnight
+func parseGTFSTime(value string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("Invalid GTFS time: %q", value)
+	}
+
+	seconds := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid GTFS time: %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+var zagreb = mustLoadLocation("Europe/Zagreb")
+
+// formatZagrebTime formats a unix epoch as ISO-8601 in local time, for the humans reading the API
+func formatZagrebTime(epoch int64) string {
+	return time.Unix(epoch, 0).In(zagreb).Format(time.RFC3339)
+}
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
+}
+
+// serviceDayStart returns the reference point GTFS times are relative to for the given service date (YYYYMMDD),
+// which is "noon minus 12h" so that it's correct on the days DST changes
+func serviceDayStart(date string) (time.Time, error) {
+	day, err := time.ParseInLocation("20060102", date, zagreb)
+	if err != nil {
+		return time.Time{}, err
+	}
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, zagreb)
+	return noon.Add(-12 * time.Hour), nil
+}
+
+// getTripStart combines the trip's start date and time from the feed,
+// the time is relative to the service day so it can be past midnight
+func getTripStart(trip *gtfs.TripDescriptor) (time.Time, bool) {
+	if trip.GetStartDate() == "" || trip.GetStartTime() == "" {
+		return time.Time{}, false
+	}
+	day, err := serviceDayStart(trip.GetStartDate())
+	if err != nil {
+		return time.Time{}, false
+	}
+	seconds, err := parseGTFSTime(trip.GetStartTime())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day.Add(time.Duration(seconds) * time.Second), true
+}
+
+// getScheduledStopTime returns the trip's scheduled stop time matching the update's stop sequence or stop ID
+func getScheduledStopTime(tripID TripID, update *gtfs.TripUpdate_StopTimeUpdate) (StopTime, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	tripStopTimes := schedule.StopTimes[tripID]
+	idx := slices.IndexFunc(tripStopTimes, func(st StopTime) bool {
+		if update.StopSequence != nil {
+			return st.Sequence == update.GetStopSequence()
+		}
+		return st.StopID == StopID(update.GetStopId())
+	})
+	if idx == -1 {
+		return StopTime{}, false
+	}
+	return tripStopTimes[idx], true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Most endpoints build their whole response before encoding it, which is fine for a few hundred vehicles,
+// they're encoded through newJSONEncoder which refuses a response over the limit with a 500 instead.
+// The ones which can get large stream instead, flushing as they go, and are cut off at the limit:
+//   - /trips, one route at a time
+//   - /vehicles as GeoJSON, one route at a time
+//   - /vehicles.ndjson, one route at a time
+//
+// The SSE and WebSocket streams send one snapshot per message for as long as the client stays,
+// a message is never larger than /vehicles so they aren't capped.
+var maxResponseSize = flag.Int64("max-response-size", 64<<20, "bytes after which a response is refused, or cut off if it's already streaming, 0 for no limit")
+
+var errResponseTooLarge = errors.New("Response too large")
+
+// cappedWriter fails writes past the limit, so a pathological request can't stream without end
+type cappedWriter struct {
+	w         io.Writer
+	remaining int64
+	written   bool
+}
+
+func newCappedWriter(w io.Writer) io.Writer {
+	if *maxResponseSize <= 0 {
+		return w
+	}
+	return &cappedWriter{w: w, remaining: *maxResponseSize}
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > c.remaining {
+		// nothing has been sent yet, so the client can still be told why instead of getting an empty 200
+		if rw, ok := c.w.(http.ResponseWriter); ok && !c.written {
+			c.written = true
+			writeJSONError(rw, http.StatusInternalServerError, "The response is over -max-response-size")
+		}
+		return 0, errResponseTooLarge
+	}
+	c.remaining -= int64(len(p))
+	c.written = true
+	return c.w.Write(p)
+}
+
+// flush sends what was written so far, if the connection supports it
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// jsonStream writes a JSON document a piece at a time through newCappedWriter, buffered until flush.
+// For ?pretty=1 each piece is indented as deep as it's nested, so the output matches what newJSONEncoder would write
+type jsonStream struct {
+	w                      http.ResponseWriter
+	buffer                 *bufio.Writer
+	pretty                 bool
+	newline, indent, colon string
+}
+
+func newJSONStream(w http.ResponseWriter, r *http.Request) *jsonStream {
+	s := &jsonStream{w: w, buffer: bufio.NewWriter(newCappedWriter(w)), colon: ":"}
+	if r.URL.Query().Get("pretty") == "1" {
+		s.pretty, s.newline, s.indent, s.colon = true, "\n", "  ", ": "
+	}
+	return s
+}
+
+// raw writes punctuation as it is
+func (s *jsonStream) raw(text string) error {
+	_, err := s.buffer.WriteString(text)
+	return err
+}
+
+// value writes v, with every line but the first starting with prefix when pretty
+func (s *jsonStream) value(v any, prefix string) error {
+	var data []byte
+	var err error
+	if s.pretty {
+		data, err = json.MarshalIndent(v, prefix, s.indent)
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.buffer.Write(data)
+	return err
+}
+
+// key starts an object member nested depth levels deep, on its own line when pretty
+func (s *jsonStream) key(name string, depth int) error {
+	if err := s.raw(s.newline + s.prefix(depth)); err != nil {
+		return err
+	}
+	if err := s.value(name, ""); err != nil {
+		return err
+	}
+	return s.raw(s.colon)
+}
+
+// prefix is the indentation of a value nested depth levels deep
+func (s *jsonStream) prefix(depth int) string {
+	return strings.Repeat(s.indent, depth)
+}
+
+// flush sends everything written so far, an error means the client went away or the response hit -max-response-size
+func (s *jsonStream) flush() error {
+	if err := s.buffer.Flush(); err != nil {
+		return err
+	}
+	flush(s.w)
+	return nil
+}
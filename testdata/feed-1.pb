@@ -0,0 +1,14 @@
+
+
+2.0Ϫ.
+101"'
+
+0_1_601*6
+@7BA(ϪB
+1010
+201")
+
+0_1_1401*14
+?57B
+A(ϪB
+201
\ No newline at end of file
@@ -0,0 +1,20 @@
+
+
+2.0Ϫ.
+101"'
+
+0_1_601*6
+@7BA(ϪB
+1010
+201")
+
+0_1_1401*14
+L77B
+A(ϪB
+2010
+202")
+
+0_1_1499*14
+X97B
+A(ϪB
+202
\ No newline at end of file
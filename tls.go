@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var tlsCert = flag.String("tls-cert", "", "certificate file to serve HTTPS with, needs -tls-key")
+var tlsKey = flag.String("tls-key", "", "private key file of -tls-cert")
+var autocertDomain = flag.String("autocert-domain", "", "domain to get a Let's Encrypt certificate for, serving HTTPS on :443 and the ACME challenges on :80")
+var autocertCache = flag.String("autocert-cache", "autocert", "directory the Let's Encrypt certificates are kept in")
+var unixSocket = flag.String("unix-socket", "", "serve plain HTTP on this Unix domain socket instead of TCP, for running behind a local reverse proxy")
+var unixSocketMode = flag.String("unix-socket-mode", "0660", "permissions of -unix-socket, the proxy needs to be able to write to it")
+
+// listenUnix listens on the socket, replacing the one a previous run left behind
+func listenUnix(path string, mode string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid socket mode %q: %v", mode, err)
+	}
+
+	// only a socket is removed, a typo in the path shouldn't delete a file
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("Could not remove stale socket: %v", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, fs.FileMode(perm)); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Could not set socket permissions: %v", err)
+	}
+	return listener, nil
+}
+
+// serve listens over plain HTTP unless TLS is configured.
+//
+// Over TLS clients negotiate HTTP/2, which is fine for the SSE endpoint, even preferable: browsers allow only
+// six HTTP/1.1 connections per host and every open tab holds one of them for the stream, while HTTP/2 multiplexes.
+// WebSockets still upgrade over their own HTTP/1.1 connection.
+func serve(handler http.Handler) {
+	switch {
+	case *unixSocket != "":
+		if *autocertDomain != "" || *tlsCert != "" || *tlsKey != "" {
+			log.Fatal("TLS isn't supported on -unix-socket, the reverse proxy in front of it terminates it")
+		}
+		listener, err := listenUnix(*unixSocket, *unixSocketMode)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", *unixSocket, err)
+		}
+		log.Printf("Server running on %s\n", *unixSocket)
+		log.Fatal(http.Serve(listener, handler))
+
+	case *autocertDomain != "":
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(*autocertDomain),
+			Cache:      autocert.DirCache(*autocertCache),
+		}
+		go func() {
+			// plain HTTP answers the challenges and redirects everything else to HTTPS
+			log.Fatal(http.ListenAndServe("0.0.0.0:80", manager.HTTPHandler(nil)))
+		}()
+
+		server := &http.Server{Addr: "0.0.0.0:443", Handler: handler, TLSConfig: manager.TLSConfig()}
+		log.Printf("Server running on port 443 for %s\n", *autocertDomain)
+		log.Fatal(server.ListenAndServeTLS("", ""))
+
+	case *tlsCert != "" || *tlsKey != "":
+		if *tlsCert == "" || *tlsKey == "" {
+			log.Fatal("Both -tls-cert and -tls-key are needed to serve HTTPS")
+		}
+		log.Println("Server running on port 8080 over HTTPS")
+		log.Fatal(http.ListenAndServeTLS("0.0.0.0:8080", *tlsCert, *tlsKey, handler))
+
+	default:
+		log.Println("Server running on port 8080")
+		log.Fatal(http.ListenAndServe("0.0.0.0:8080", handler))
+	}
+}
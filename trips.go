@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+type TripInfo struct {
+	Headsign             string        `json:"headsign"`
+	ShortName            string        `json:"short_name"`
+	DirectionID          string        `json:"direction_id"`
+	ShapeID              ShapeID       `json:"shape_id"`
+	ServiceID            string        `json:"service_id"`
+	WheelchairAccessible Accessibility `json:"wheelchair_accessible"`
+	Frequencies          []Frequency   `json:"frequencies,omitempty"`
+}
+
+// getRouteTripInfos returns the route's trips in the given schedule
+func getRouteTripInfos(s *Schedule, routeID RouteID) map[TripID]TripInfo {
+	result := make(map[TripID]TripInfo, len(s.Trips[routeID]))
+	for tripID, trip := range s.Trips[routeID] {
+		result[tripID] = TripInfo{
+			Headsign:             trip.Headsign,
+			ShortName:            trip.ShortName,
+			DirectionID:          trip.Direction,
+			ShapeID:              trip.ShapeID,
+			ServiceID:            trip.ServiceID,
+			WheelchairAccessible: trip.WheelchairAccessible,
+			Frequencies:          trip.Frequencies,
+		}
+	}
+	return result
+}
+
+// tripsHandler exposes the schedule's trips, so clients can resolve trip IDs themselves,
+// the whole schedule is several megabytes of JSON so it's streamed a route at a time
+func tripsHandler(w http.ResponseWriter, r *http.Request) {
+	// a new schedule is swapped in as a whole, so holding on to this one keeps the response consistent
+	mu.RLock()
+	current := schedule
+	mu.RUnlock()
+
+	routeIDs := slices.SortedFunc(maps.Keys(current.Trips), compareRouteIDs)
+	if onlyRoute := RouteID(r.URL.Query().Get("route")); onlyRoute != "" {
+		routeIDs = []RouteID{}
+		if _, exists := current.Trips[onlyRoute]; exists {
+			routeIDs = append(routeIDs, onlyRoute)
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+
+	stream := newJSONStream(w, r)
+	stream.raw("{")
+	stream.key("schedule_filename", 1)
+	stream.value(current.Filename, "")
+	stream.raw(",")
+	stream.key("trips", 1)
+	stream.raw("{")
+	for i, routeID := range routeIDs {
+		if i > 0 {
+			stream.raw(",")
+		}
+		stream.key(string(routeID), 2)
+		stream.value(getRouteTripInfos(current, routeID), stream.prefix(2))
+		if err := stream.flush(); err != nil {
+			// it's cut off either way
+			log.Println("Stopped streaming trips: ", err)
+			return
+		}
+	}
+	if len(routeIDs) > 0 {
+		stream.raw(stream.newline + stream.prefix(1))
+	}
+	stream.raw("}" + stream.newline + "}\n")
+	stream.flush()
+}
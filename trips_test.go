@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setSchedule swaps s in for the duration of the test
+func setSchedule(t testing.TB, s *Schedule) {
+	t.Helper()
+	mu.Lock()
+	previous := schedule
+	schedule = s
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		schedule = previous
+		mu.Unlock()
+	})
+}
+
+func TestTripsHandlerPretty(t *testing.T) {
+	setSchedule(t, &Schedule{
+		Filename: "zet-gtfs.zip",
+		Trips: RoutesToTrips{
+			"6":  {"0_1_601": {Headsign: "Sopot", ShapeID: "6_1"}},
+			"14": {"0_1_1401": {Headsign: "Zapruđe"}, "0_1_1402": {Headsign: "Mihaljevac", Direction: "1"}},
+		},
+	})
+
+	get := func(target string) string {
+		recorder := httptest.NewRecorder()
+		tripsHandler(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", target, recorder.Code)
+		}
+		return recorder.Body.String()
+	}
+
+	compact := get("/trips")
+	pretty := get("/trips?pretty=1")
+	if strings.Contains(compact, "\n ") {
+		t.Errorf("compact output is indented: %s", compact)
+	}
+	if !strings.Contains(pretty, "\n    \"14\": {\n      \"0_1_1401\": {\n") {
+		t.Errorf("pretty output isn't indented by depth: %s", pretty)
+	}
+
+	var fromCompact, fromPretty any
+	if err := json.Unmarshal([]byte(compact), &fromCompact); err != nil {
+		t.Fatalf("compact output isn't JSON: %v\n%s", err, compact)
+	}
+	if err := json.Unmarshal([]byte(pretty), &fromPretty); err != nil {
+		t.Fatalf("pretty output isn't JSON: %v\n%s", err, pretty)
+	}
+	if !reflect.DeepEqual(fromCompact, fromPretty) {
+		t.Errorf("pretty output differs from compact:\n%s\n%s", compact, pretty)
+	}
+
+	var empty any
+	if err := json.Unmarshal([]byte(get("/trips?pretty=1&route=missing")), &empty); err != nil {
+		t.Errorf("pretty output without routes isn't JSON: %v", err)
+	}
+}
+
+func TestTripsHandlerMaxResponseSize(t *testing.T) {
+	setSchedule(t, &Schedule{Trips: RoutesToTrips{"6": {"0_1_601": {Headsign: "Sopot"}}}})
+	previous := *maxResponseSize
+	*maxResponseSize = 8
+	t.Cleanup(func() { *maxResponseSize = previous })
+
+	recorder := httptest.NewRecorder()
+	tripsHandler(recorder, httptest.NewRequest(http.MethodGet, "/trips?pretty=1", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+}
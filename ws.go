@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// how long a single message may take to reach the client before the connection is dropped
+const wsWriteTimeout = 10 * time.Second
+
+// wsSubscription is the control message a WebSocket client sends to change which vehicles it gets,
+// empty lists mean everything
+type wsSubscription struct {
+	Routes []RouteID `json:"routes"`
+	Types  []string  `json:"types"`
+	// [west, south, east, north] like a GeoJSON bbox
+	BBox []float64 `json:"bbox"`
+}
+
+func parseSubscription(data []byte) (wsSubscription, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var subscription wsSubscription
+	if err := decoder.Decode(&subscription); err != nil {
+		return wsSubscription{}, err
+	}
+	return subscription, nil
+}
+
+func (s wsSubscription) filters() ([]vehicleFilter, error) {
+	filters := []vehicleFilter{}
+
+	if slices.Contains(s.Routes, "") {
+		return nil, fmt.Errorf("Route IDs can't be empty")
+	}
+
+	if len(s.Routes) > 0 {
+		routes := s.Routes
+		filters = append(filters, func(routeID RouteID, v Vehicle) bool {
+			return slices.Contains(routes, routeID)
+		})
+	}
+
+	if len(s.Types) > 0 {
+		routeTypes := []int{}
+		for _, typeName := range s.Types {
+			routeType, exists := routeTypesByName[typeName]
+			if !exists {
+				return nil, fmt.Errorf("Unknown vehicle type %q, expected tram or bus", typeName)
+			}
+			routeTypes = append(routeTypes, routeType)
+		}
+		filters = append(filters, func(routeID RouteID, v Vehicle) bool {
+			route, exists := getRoute(routeID)
+			return exists && slices.Contains(routeTypes, route.Type)
+		})
+	}
+
+	if s.BBox != nil {
+		if len(s.BBox) != 4 {
+			return nil, fmt.Errorf("Expected the bbox as [west, south, east, north], got %d numbers", len(s.BBox))
+		}
+		west, south, east, north := s.BBox[0], s.BBox[1], s.BBox[2], s.BBox[3]
+		if west > east || south > north || west < -180 || east > 180 || south < -90 || north > 90 {
+			return nil, fmt.Errorf("Invalid bbox %v", s.BBox)
+		}
+		filters = append(filters, func(routeID RouteID, v Vehicle) bool {
+			lat, lon := float64(v.Latitude), float64(v.Longitude)
+			return west <= lon && lon <= east && south <= lat && lat <= north
+		})
+	}
+
+	return filters, nil
+}
+
+// wsHandler pushes the same snapshots as the SSE stream, but lets the client change its filters on the fly
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: []string{allowedOrigin}})
+	if err != nil {
+		log.Println("Could not accept WebSocket connection: ", err)
+		return
+	}
+	defer conn.CloseNow()
+
+	log.Println("WebSocket client connected")
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	subscriptions := make(chan []vehicleFilter)
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+
+			subscription, err := parseSubscription(data)
+			if err != nil {
+				log.Println("Ignoring malformed WebSocket message: ", err)
+				continue
+			}
+			filters, err := subscription.filters()
+			if err != nil {
+				log.Println("Ignoring invalid WebSocket subscription: ", err)
+				continue
+			}
+
+			select {
+			case subscriptions <- filters:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	client := vehicleBroadcaster.subscribe()
+	defer vehicleBroadcaster.unsubscribe(client)
+
+	filters := []vehicleFilter{}
+	send := func(snapshot *Snapshot) error {
+		data, _ := json.Marshal(vehiclesMessage{
+			Vehicles:      applyFilters(snapshot.Routes, filters),
+			Predicted:     snapshot.Predicted,
+			TotalVehicles: snapshot.TotalVehicles,
+			TotalRoutes:   snapshot.TotalRoutes,
+			Stale:         isFeedStale(),
+			Sequence:      snapshot.Sequence,
+			Units:         apiUnits(),
+		})
+
+		writeCtx, cancelWrite := context.WithTimeout(ctx, wsWriteTimeout)
+		defer cancelWrite()
+		return conn.Write(writeCtx, websocket.MessageText, data)
+	}
+
+	if err := send(allVehicles.Load().(*Snapshot)); err != nil {
+		log.Println("WebSocket client disconnected: ", err)
+		return
+	}
+	for {
+		select {
+		case snapshot := <-client.updates:
+			if err := send(snapshot); err != nil {
+				log.Println("WebSocket client disconnected: ", err)
+				return
+			}
+		case filters = <-subscriptions:
+			// the client shouldn't have to wait for the next poll to see its new selection
+			if err := send(allVehicles.Load().(*Snapshot)); err != nil {
+				log.Println("WebSocket client disconnected: ", err)
+				return
+			}
+		case <-client.tooSlow:
+			log.Printf("WebSocket client disconnected for missing %d updates in a row\n", maxClientDrops)
+			conn.Close(websocket.StatusPolicyViolation, "too slow")
+			return
+		case <-ctx.Done():
+			log.Println("WebSocket client disconnected")
+			return
+		}
+	}
+}